@@ -25,8 +25,8 @@ class Acpi(device.Driver):
     driver = "acpi"
 
     def create(self, **kwargs):
-        # Our ACPI implementation is currently
-        # quite broken (we don't even have a DSDT).
+        # Our ACPI implementation is minimal (we
+        # provide only a power button and soft-off).
         # Therefore, to stop Linux from complaining,
         # we intentionally disable power-states.
         return super(Acpi, self).create(
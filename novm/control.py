@@ -17,6 +17,9 @@ Control functions.
 import os
 import socket
 import select
+import signal
+import struct
+import fcntl
 import json
 import binascii
 import sys
@@ -93,6 +96,83 @@ class Control(object):
 
         return obj.get("result")
 
+    def events(self):
+
+        fobj = self._sock.makefile(bufsize=0)
+        fobj.write("NOVM EVT\n")
+        fobj.flush()
+
+        # Yield events until the VM goes away.
+        while True:
+            line = fobj.readline()
+            if not line:
+                break
+            yield json.loads(line)
+
+    def gdb(self):
+
+        self._sock.sendall("NOVM GDB\n")
+
+        # Bridge our stdin and stdout to the stub.
+        # This is intended to be used from gdb as:
+        #   target remote | novm gdb --name=...
+        while True:
+            (readable, _, _) = select.select([0, self._sock], [], [])
+            if 0 in readable:
+                data = os.read(0, 4096)
+                if not data:
+                    break
+                self._sock.sendall(data)
+            if self._sock in readable:
+                data = self._sock.recv(4096)
+                if not data:
+                    break
+                os.write(1, data)
+
+    def copy(self, put=None, get=None):
+
+        fobj = self._sock.makefile(bufsize=0)
+        fobj.write("NOVM CPY\n")
+        copy_cmd = {}
+        if put is not None:
+            copy_cmd["put"] = put
+        if get is not None:
+            copy_cmd["get"] = get
+        json.dump(copy_cmd, fobj)
+        fobj.write("\n")
+        fobj.flush()
+
+        # Expect a None to indicate we've started.
+        obj = json.loads(fobj.readline())
+        if obj is not None:
+            raise Exception(obj)
+
+        if put is not None:
+            # Send the archive from stdin.
+            while True:
+                data = os.read(0, 4096)
+                if not data:
+                    break
+                self._sock.sendall(data)
+            self._sock.shutdown(socket.SHUT_WR)
+
+            # Wait for the result.
+            obj = json.loads(fobj.readline())
+            if obj is not None:
+                raise Exception(obj)
+        else:
+            # Write the archive to stdout.
+            while True:
+                data = self._sock.recv(4096)
+                if not data:
+                    break
+                os.write(1, data)
+
+    def _winsize(self):
+        (rows, cols, _, _) = struct.unpack(
+            "HHHH", fcntl.ioctl(0, termios.TIOCGWINSZ, "\0" * 8))
+        return {"rows": rows, "cols": cols}
+
     def run(self, command, env=None, cwd=None, terminal=False):
         if env is None:
             env = ["%s=%s" % (k, v) for (k, v) in list(os.environ.items())]
@@ -115,6 +195,9 @@ class Control(object):
         json.dump(start_cmd, fobj)
         fobj.flush()
 
+        # Our original signal handlers.
+        orig_handlers = {}
+
         try:
             try:
                 # Save our terminal attributes and
@@ -140,13 +223,38 @@ class Control(object):
             # Remember if we've seen an ~ already.
             seen_tilde = False
 
+            # Forward signals and terminal resizes.
+            # These are queued up by the handlers and
+            # sent from the loop below, so that they are
+            # never interleaved with a partial write.
+            pending = []
+            def forward_signal(signum, frame):
+                pending.append({"signal": signum})
+            def forward_resize(signum, frame):
+                pending.append(self._winsize())
+            for signum in (signal.SIGINT, signal.SIGTERM, signal.SIGHUP):
+                orig_handlers[signum] = signal.signal(signum, forward_signal)
+            if is_terminal:
+                orig_handlers[signal.SIGWINCH] = signal.signal(
+                    signal.SIGWINCH, forward_resize)
+                pending.append(self._winsize())
+
             # Poll and transform the event stream.
             # This will basically turn this process
             # into a proxy for the remote process.
             read_set = [fobj, sys.stdin]
 
             while True:
-                to_read, _, _ = select.select(read_set, [], [])
+                try:
+                    to_read, _, _ = select.select(read_set, [], [])
+                except select.error:
+                    # Interrupted by a signal.
+                    to_read = []
+
+                while pending:
+                    json.dump(pending.pop(0), fobj)
+                    fobj.write("\n")
+                    fobj.flush()
 
                 if fobj in to_read:
                     # Decode the object.
@@ -163,10 +271,15 @@ class Control(object):
                         # Server is done.
                         raise IOError()
 
-                    elif isinstance(obj, basestring):
-                        data = binascii.a2b_base64(obj)
-                        sys.stdout.write(data)
-                        sys.stdout.flush()
+                    elif isinstance(obj, dict):
+                        # Output from the process.
+                        data = binascii.a2b_base64(obj["data"] or "")
+                        if obj.get("stream") == "stderr":
+                            output = sys.stderr
+                        else:
+                            output = sys.stdout
+                        output.write(data)
+                        output.flush()
 
                     elif isinstance(obj, int):
                         # Remember our exitcode.
@@ -185,21 +298,22 @@ class Control(object):
                             seen_tilde = False
 
                     if data:
-                        data = binascii.b2a_base64(data)
-
-                    json.dump(data, fobj)
-                    fobj.write("\n")
-                    fobj.flush()
-                    if not data:
+                        json.dump(binascii.b2a_base64(data), fobj)
+                    else:
                         # We don't close the socket.
-                        # We simply stop sending data.
+                        # We simply close the remote stdin.
+                        json.dump({"close-stdin": True}, fobj)
                         read_set.remove(sys.stdin)
+                    fobj.write("\n")
+                    fobj.flush()
 
         except IOError:
             # Socket was closed.
             sys.exit(exitcode)
 
         finally:
+            for (signum, handler) in orig_handlers.items():
+                signal.signal(signum, handler)
             if is_terminal:
                 # Restore all of our original terminal attributes.
                 termios.tcsetattr(0, termios.TCSAFLUSH, orig_tc_attrs)
@@ -397,6 +397,20 @@ class NovmManager(object):
         ctrl = control.Control(ctrl_path, bind=False)
         return ctrl.rpc(command, **args)
 
+    def gdb(self, id=None, name=None):
+        """ Attach gdb (over stdin/stdout) to the given instance. """
+        obj_id = self._instances.find(obj_id=id, name=name)
+        ctrl_path = os.path.join(self._controls, "%s.ctrl" % obj_id)
+        ctrl = control.Control(ctrl_path, bind=False)
+        return ctrl.gdb()
+
+    def copy(self, id=None, name=None, put=None, get=None):
+        """ Copy a tar archive (over stdin/stdout) in or out. """
+        obj_id = self._instances.find(obj_id=id, name=name)
+        ctrl_path = os.path.join(self._controls, "%s.ctrl" % obj_id)
+        ctrl = control.Control(ctrl_path, bind=False)
+        return ctrl.copy(put=put, get=get)
+
     def run_noguest(self, command, id=None, name=None, **kwargs):
         """ Run a command inside the given guest. """
         obj_id = self._instances.find(obj_id=id, name=name)
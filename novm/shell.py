@@ -185,6 +185,113 @@ class NovmShell(object):
             terminal=terminal,
             command=command)
 
+    def forward(self,
+            id=cli.StrOpt("The instance id."),
+            name=cli.StrOpt("The instance name."),
+            bind=cli.StrOpt("The host address (default: 127.0.0.1)."),
+            *mapping):
+
+        """
+        Forward a host port to a guest port.
+
+        The mapping is host:guest, where each side is either
+        a port or a unix socket path. Guest ports are always
+        connected on localhost inside the guest.
+
+        For example:
+
+            novm forward --name=... 8080:80
+
+        No guest networking is required. The forward lasts until
+        the instance exits, or it is removed using unforward.
+        """
+        if len(mapping) != 1 or ":" not in mapping[0]:
+            raise exceptions.CommandInvalid()
+
+        def parse(spec, addr):
+            if spec.isdigit():
+                return ("tcp", "%s:%s" % (addr, spec))
+            else:
+                return ("unix", spec)
+
+        if bind is None:
+            bind = "127.0.0.1"
+        (host, guest) = mapping[0].split(":", 1)
+        (network, address) = parse(host, bind)
+        (guest_network, guest_address) = parse(guest, "127.0.0.1")
+
+        return self._manager.rpc(
+            id=id,
+            name=name,
+            command="forward",
+            args={
+                "network": network,
+                "address": address,
+                "guest-network": guest_network,
+                "guest-address": guest_address,
+            })
+
+    def unforward(self,
+            id=cli.StrOpt("The instance id."),
+            name=cli.StrOpt("The instance name."),
+            forward=cli.IntOpt("The forward id.")):
+
+        """ Remove a port forward. """
+        if forward is None:
+            raise exceptions.CommandInvalid()
+
+        return self._manager.rpc(
+            id=id,
+            name=name,
+            command="unforward",
+            args={"id": forward})
+
+    def put(self,
+            id=cli.StrOpt("The instance id."),
+            name=cli.StrOpt("The instance name."),
+            dest=cli.StrOpt("The guest directory (default: /).")):
+
+        """
+        Copy files into a novm.
+
+        This reads a tar archive from stdin, for example:
+
+            tar -c . | novm put --name=... --dest=/tmp
+        """
+        if dest is None:
+            dest = "/"
+        return self._manager.copy(id=id, name=name, put=dest)
+
+    def get(self,
+            id=cli.StrOpt("The instance id."),
+            name=cli.StrOpt("The instance name."),
+            *paths):
+
+        """
+        Copy files out of a novm.
+
+        This writes a tar archive to stdout, for example:
+
+            novm get --name=... /proc/cpuinfo | tar -x
+        """
+        if len(paths) == 0:
+            raise exceptions.CommandInvalid()
+        return self._manager.copy(id=id, name=name, get=list(paths))
+
+    def gdb(self,
+            id=cli.StrOpt("The instance id."),
+            name=cli.StrOpt("The instance name.")):
+
+        """
+        Debug the guest kernel with gdb.
+
+        This speaks the gdb remote protocol over stdin and
+        stdout, so it should be used from within gdb:
+
+            (gdb) target remote | novm gdb --name=...
+        """
+        return self._manager.gdb(id=id, name=name)
+
     def clean(self,
             id=cli.StrOpt("The instance id."),
             name=cli.StrOpt("The instance name.")):
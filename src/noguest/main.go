@@ -30,6 +30,9 @@ var control = flag.String("control", "/dev/vport0p0", "control file")
 // Should this always run a server.
 var server_fd = flag.Int("serverfd", -1, "run RPC server")
 
+// Are we running as a helper for a child?
+var exec_spec = flag.String(rpc.ExecFlag, "", "exec helper (internal)")
+
 func mount(fs string, location string) error {
 
 	// Do we have the location?
@@ -53,6 +56,12 @@ func main() {
 	// Parse flags.
 	flag.Parse()
 
+	if *exec_spec != "" {
+		// Setup and exec (see rpc.Exec).
+		err := rpc.Exec(*exec_spec, flag.Args())
+		log.Fatal(err)
+	}
+
 	if *server_fd == -1 {
 		// Open the console.
 		if f, err := os.OpenFile(*control, os.O_RDWR, 0); err != nil {
@@ -110,6 +119,12 @@ func main() {
 			// things, like our hostname we do some of that here.
 			syscall.Sethostname([]byte("novm"))
 
+			// We need /proc to find ourselves (see rpc.Exec).
+			err := mount("proc", "/proc")
+			if err != nil {
+				log.Printf("Unable to mount /proc: %s", err.Error())
+			}
+
 		default:
 			// What the heck is this?
 			log.Fatal(protocol.UnknownCommand)
@@ -20,3 +20,10 @@ import (
 
 var UnknownStatus = errors.New("Unknown status?")
 var UnknownCommand = errors.New("Unknown command?")
+
+// Stream errors.
+var InvalidFrame = errors.New("Invalid frame?")
+var StreamReset = errors.New("Stream reset?")
+var StreamClosed = errors.New("Stream closed?")
+var SessionClosed = errors.New("Session closed?")
+var TooManyStreams = errors.New("Too many pending streams?")
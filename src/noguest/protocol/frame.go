@@ -0,0 +1,137 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+)
+
+//
+// Frames --
+//
+// After the initial status and command bytes, the
+// channel carries a sequence of frames. Each frame
+// has a 9 byte header (a big-endian stream id, the
+// frame type and a big-endian payload length), and
+// then the payload. Streams are opened by either side
+// (the host uses odd ids, the guest even ids), and the
+// payload of the open frame names the stream.
+//
+// The name is a JSON array of strings: the kind of
+// stream followed by its arguments (see StreamName).
+// Arguments may contain anything (i.e. unix paths).
+//
+
+const (
+	FrameOpen   = 0x1 // Payload is the stream name.
+	FrameData   = 0x2 // Payload is data.
+	FrameWindow = 0x3 // Payload is a 32-bit window increment.
+	FrameClose  = 0x4 // The sender won't send more data.
+	FrameReset  = 0x5 // Payload is an (optional) error.
+)
+
+const (
+	FrameHeaderSize = 9
+	FrameMaxData    = 16384
+	FrameMaxSize    = 65536
+)
+
+//
+// The receive window for each stream.
+// We won't send more than this without
+// the other side consuming the data.
+//
+const StreamWindowSize = 262144
+
+// Our well-known streams.
+const (
+	StreamRpc    = "rpc"    // A JSON-RPC connection.
+	StreamOutput = "output" // Process output (pid).
+	StreamInput  = "input"  // Process input (pid).
+	StreamDial   = "dial"   // A connection (network, address).
+)
+
+func StreamName(kind string, args ...string) string {
+	parts, _ := json.Marshal(append([]string{kind}, args...))
+	return string(parts)
+}
+
+//
+// ParseStreamName --
+//
+// Returns the kind and arguments for the given name.
+// If the name is not valid, the kind will be empty.
+//
+func ParseStreamName(name string) (string, []string) {
+	var parts []string
+	err := json.Unmarshal([]byte(name), &parts)
+	if err != nil || len(parts) == 0 {
+		return "", nil
+	}
+	return parts[0], parts[1:]
+}
+
+type frame struct {
+	stream  uint32
+	kind    uint8
+	payload []byte
+}
+
+func readFrame(reader io.Reader) (frame, error) {
+
+	header := make([]byte, FrameHeaderSize, FrameHeaderSize)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		return frame{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header[5:9])
+	if length > FrameMaxSize {
+		return frame{}, InvalidFrame
+	}
+
+	payload := make([]byte, length, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		return frame{}, err
+	}
+
+	return frame{
+		stream:  binary.BigEndian.Uint32(header[0:4]),
+		kind:    header[4],
+		payload: payload,
+	}, nil
+}
+
+func writeFrame(writer io.Writer, f frame) error {
+
+	data := make([]byte, FrameHeaderSize+len(f.payload))
+	binary.BigEndian.PutUint32(data[0:4], f.stream)
+	data[4] = f.kind
+	binary.BigEndian.PutUint32(data[5:9], uint32(len(f.payload)))
+	copy(data[FrameHeaderSize:], f.payload)
+
+	for len(data) > 0 {
+		n, err := writer.Write(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
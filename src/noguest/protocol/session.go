@@ -0,0 +1,229 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// How many unaccepted streams we allow.
+const SessionAcceptBacklog = 128
+
+//
+// Session --
+//
+// A set of streams multiplexed over a single channel.
+//
+// Each stream is independently flow controlled, so a
+// slow consumer (or a large transfer) on one stream
+// won't hold up any of the others.
+//
+type Session struct {
+	// The underlying channel.
+	conn io.ReadWriteCloser
+
+	// Active streams.
+	streams map[uint32]*Stream
+
+	// The next stream id we will use.
+	next_id uint32
+
+	// Streams opened by the other side.
+	accepts chan *Stream
+
+	// The error (once the session is done).
+	err  error
+	done chan bool
+
+	// Protects the above.
+	lock sync.Mutex
+
+	// Serializes frames.
+	write_lock sync.Mutex
+}
+
+func NewSession(conn io.ReadWriteCloser, client bool) *Session {
+
+	session := &Session{
+		conn:    conn,
+		streams: make(map[uint32]*Stream),
+		accepts: make(chan *Stream, SessionAcceptBacklog),
+		done:    make(chan bool),
+	}
+	if client {
+		session.next_id = 1
+	} else {
+		session.next_id = 2
+	}
+
+	go session.run()
+	return session
+}
+
+func (session *Session) write(f frame) error {
+	session.write_lock.Lock()
+	defer session.write_lock.Unlock()
+	return writeFrame(session.conn, f)
+}
+
+//
+// Open --
+//
+// Open a new stream with the given name.
+//
+func (session *Session) Open(name string) (*Stream, error) {
+
+	session.lock.Lock()
+	if session.err != nil {
+		session.lock.Unlock()
+		return nil, session.err
+	}
+	id := session.next_id
+	session.next_id += 2
+	stream := newStream(session, id, name)
+	session.streams[id] = stream
+	session.lock.Unlock()
+
+	err := session.write(frame{
+		stream:  id,
+		kind:    FrameOpen,
+		payload: []byte(name),
+	})
+	if err != nil {
+		session.remove(stream)
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+//
+// Accept --
+//
+// Wait for a stream opened by the other side.
+//
+func (session *Session) Accept() (*Stream, error) {
+	select {
+	case stream := <-session.accepts:
+		return stream, nil
+	case <-session.done:
+		return nil, session.err
+	}
+}
+
+func (session *Session) Close() error {
+	session.fail(SessionClosed)
+	return session.conn.Close()
+}
+
+func (session *Session) lookup(id uint32) *Stream {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	return session.streams[id]
+}
+
+func (session *Session) remove(stream *Stream) {
+	session.lock.Lock()
+	defer session.lock.Unlock()
+	if session.streams[stream.id] == stream {
+		delete(session.streams, stream.id)
+	}
+}
+
+func (session *Session) fail(err error) {
+
+	session.lock.Lock()
+	if session.err != nil {
+		session.lock.Unlock()
+		return
+	}
+	session.err = err
+	close(session.done)
+	streams := session.streams
+	session.streams = make(map[uint32]*Stream)
+	session.lock.Unlock()
+
+	for _, stream := range streams {
+		stream.reset(err)
+	}
+}
+
+func (session *Session) run() {
+
+	for {
+		f, err := readFrame(session.conn)
+		if err != nil {
+			session.fail(err)
+			return
+		}
+
+		switch f.kind {
+		case FrameOpen:
+			stream := newStream(session, f.stream, string(f.payload))
+
+			// An open for an existing id replaces it.
+			// This happens if the other side restarts (for
+			// example, after the VM is restored).
+			session.lock.Lock()
+			old_stream := session.streams[f.stream]
+			session.streams[f.stream] = stream
+			session.lock.Unlock()
+			if old_stream != nil {
+				old_stream.reset(StreamReset)
+			}
+
+			select {
+			case session.accepts <- stream:
+			default:
+				go stream.Reset(TooManyStreams)
+			}
+
+		case FrameData:
+			stream := session.lookup(f.stream)
+			if stream != nil {
+				stream.push(f.payload)
+			}
+
+		case FrameWindow:
+			stream := session.lookup(f.stream)
+			if stream != nil && len(f.payload) == 4 {
+				stream.grant(int(binary.BigEndian.Uint32(f.payload)))
+			}
+
+		case FrameClose:
+			stream := session.lookup(f.stream)
+			if stream != nil {
+				stream.remoteClose()
+			}
+
+		case FrameReset:
+			stream := session.lookup(f.stream)
+			if stream != nil {
+				if len(f.payload) > 0 {
+					stream.reset(errors.New(string(f.payload)))
+				} else {
+					stream.reset(StreamReset)
+				}
+			}
+
+		default:
+			session.fail(InvalidFrame)
+			return
+		}
+	}
+}
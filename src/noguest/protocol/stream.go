@@ -0,0 +1,319 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protocol
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+//
+// Stream --
+//
+// A single bidirectional stream in a session.
+//
+// This behaves much like a socket. Closing the write
+// side (CloseWrite) is seen as EOF by the other side,
+// while Close abandons the stream in both directions.
+//
+type Stream struct {
+	session *Session
+
+	// Our id and name.
+	id   uint32
+	name string
+
+	// Received data (not yet read), and how
+	// much has been read but not yet credited.
+	buffer   []byte
+	consumed int
+
+	// How much we are still able to send.
+	window int
+
+	// Closed by either side?
+	local_closed  bool
+	remote_closed bool
+
+	// Set if the stream was reset.
+	err error
+
+	lock sync.Mutex
+	cond *sync.Cond
+}
+
+func newStream(session *Session, id uint32, name string) *Stream {
+	stream := &Stream{
+		session: session,
+		id:      id,
+		name:    name,
+		window:  StreamWindowSize,
+	}
+	stream.cond = sync.NewCond(&stream.lock)
+	return stream
+}
+
+func (stream *Stream) Name() string {
+	return stream.name
+}
+
+func (stream *Stream) Read(p []byte) (int, error) {
+
+	stream.lock.Lock()
+	for len(stream.buffer) == 0 &&
+		!stream.remote_closed &&
+		stream.err == nil {
+		stream.cond.Wait()
+	}
+
+	if len(stream.buffer) == 0 {
+		defer stream.lock.Unlock()
+		if stream.remote_closed {
+			return 0, io.EOF
+		}
+		return 0, stream.err
+	}
+
+	n := copy(p, stream.buffer)
+	stream.buffer = stream.buffer[n:]
+	if len(stream.buffer) == 0 {
+		stream.buffer = nil
+	}
+
+	// Give back some window?
+	// We do this in reasonable chunks,
+	// to avoid a frame for every read.
+	var credit int
+	stream.consumed += n
+	if stream.consumed >= StreamWindowSize/2 && stream.err == nil {
+		credit = stream.consumed
+		stream.consumed = 0
+	}
+	stream.lock.Unlock()
+
+	if credit > 0 {
+		payload := make([]byte, 4, 4)
+		binary.BigEndian.PutUint32(payload, uint32(credit))
+		stream.session.write(frame{
+			stream:  stream.id,
+			kind:    FrameWindow,
+			payload: payload,
+		})
+	}
+
+	return n, nil
+}
+
+func (stream *Stream) Write(p []byte) (int, error) {
+
+	var written int
+
+	for len(p) > 0 {
+		stream.lock.Lock()
+		for stream.window == 0 &&
+			!stream.local_closed &&
+			stream.err == nil {
+			stream.cond.Wait()
+		}
+		if stream.err != nil {
+			err := stream.err
+			stream.lock.Unlock()
+			return written, err
+		}
+		if stream.local_closed {
+			stream.lock.Unlock()
+			return written, StreamClosed
+		}
+
+		n := len(p)
+		if n > stream.window {
+			n = stream.window
+		}
+		if n > FrameMaxData {
+			n = FrameMaxData
+		}
+		stream.window -= n
+		stream.lock.Unlock()
+
+		err := stream.session.write(frame{
+			stream:  stream.id,
+			kind:    FrameData,
+			payload: p[:n],
+		})
+		if err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+//
+// CloseWrite --
+//
+// Indicate we have nothing more to send.
+//
+func (stream *Stream) CloseWrite() error {
+
+	stream.lock.Lock()
+	if stream.local_closed || stream.err != nil {
+		stream.lock.Unlock()
+		return nil
+	}
+	stream.local_closed = true
+	done := stream.remote_closed
+	stream.cond.Broadcast()
+	stream.lock.Unlock()
+
+	if done {
+		stream.session.remove(stream)
+	}
+
+	return stream.session.write(frame{
+		stream: stream.id,
+		kind:   FrameClose,
+	})
+}
+
+//
+// Reset --
+//
+// Abandon the stream, sending the given error
+// to the other side (it may be nil).
+//
+func (stream *Stream) Reset(err error) error {
+
+	stream.lock.Lock()
+	if stream.err != nil {
+		stream.lock.Unlock()
+		return nil
+	}
+	stream.err = StreamClosed
+	stream.cond.Broadcast()
+	stream.lock.Unlock()
+
+	stream.session.remove(stream)
+
+	var payload []byte
+	if err != nil {
+		payload = []byte(err.Error())
+	}
+	return stream.session.write(frame{
+		stream:  stream.id,
+		kind:    FrameReset,
+		payload: payload,
+	})
+}
+
+func (stream *Stream) Close() error {
+
+	err := stream.CloseWrite()
+
+	// If the other side is finished, then we're done.
+	// Otherwise, we tell them to stop sending.
+	stream.lock.Lock()
+	done := stream.remote_closed || stream.err != nil
+	stream.lock.Unlock()
+	if !done {
+		return stream.Reset(nil)
+	}
+
+	return err
+}
+
+//
+// Splice --
+//
+// Copy data in both directions between this
+// stream and the given connection, until both
+// sides are done. Both are closed on return.
+//
+func (stream *Stream) Splice(conn io.ReadWriteCloser) {
+
+	var done sync.WaitGroup
+	done.Add(2)
+
+	go func() {
+		defer done.Done()
+		io.Copy(conn, stream)
+		if half, ok := conn.(interface {
+			CloseWrite() error
+		}); ok {
+			half.CloseWrite()
+		} else {
+			conn.Close()
+		}
+	}()
+
+	go func() {
+		defer done.Done()
+		io.Copy(stream, conn)
+		stream.CloseWrite()
+	}()
+
+	done.Wait()
+	conn.Close()
+	stream.Close()
+}
+
+func (stream *Stream) push(data []byte) {
+
+	stream.lock.Lock()
+	if stream.err != nil || stream.remote_closed {
+		stream.lock.Unlock()
+		return
+	}
+	if len(stream.buffer)+len(data) > StreamWindowSize {
+		// The other side isn't respecting our window.
+		stream.lock.Unlock()
+		go stream.Reset(InvalidFrame)
+		return
+	}
+	stream.buffer = append(stream.buffer, data...)
+	stream.cond.Broadcast()
+	stream.lock.Unlock()
+}
+
+func (stream *Stream) grant(n int) {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	stream.window += n
+	stream.cond.Broadcast()
+}
+
+func (stream *Stream) remoteClose() {
+	stream.lock.Lock()
+	stream.remote_closed = true
+	done := stream.local_closed
+	stream.cond.Broadcast()
+	stream.lock.Unlock()
+
+	if done {
+		stream.session.remove(stream)
+	}
+}
+
+func (stream *Stream) reset(err error) {
+	stream.lock.Lock()
+	defer stream.lock.Unlock()
+	if stream.err == nil {
+		stream.err = err
+	}
+	stream.cond.Broadcast()
+	stream.session.remove(stream)
+}
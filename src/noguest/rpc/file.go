@@ -0,0 +1,262 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"io"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+// The most we will read from a file at once.
+const FileChunkSize = 65536
+
+type PutFileCommand struct {
+
+	// The file path.
+	Path string `json:"path"`
+
+	// The mode (including the type bits).
+	// If this is a directory, then it will be
+	// created and there should be no data.
+	Mode uint32 `json:"mode"`
+
+	// The link target (for a symlink).
+	// The link is created, and there should be no data.
+	Link string `json:"link"`
+
+	// The owner (-1 is unchanged, as per chown).
+	Uid int `json:"uid"`
+	Gid int `json:"gid"`
+
+	// The modification time (ns since the epoch).
+	// If this is zero, the time is not changed.
+	Mtime int64 `json:"mtime"`
+
+	// Where to write this chunk.
+	// The first chunk (offset zero) will
+	// create and truncate the file.
+	Offset int64 `json:"offset"`
+
+	// The data to write.
+	Data []byte `json:"data"`
+
+	// Is this the last chunk?
+	// If set, the mode, owner and mtime are applied.
+	Done bool `json:"done"`
+}
+
+type PutFileResult struct {
+
+	// How much was written?
+	Written int `json:"n"`
+}
+
+func (server *Server) PutFile(
+	put *PutFileCommand,
+	result *PutFileResult) error {
+
+	if put.Path == "" {
+		return syscall.EINVAL
+	}
+
+	is_link := put.Mode&syscall.S_IFMT == syscall.S_IFLNK
+
+	if put.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		// Create the directory.
+		err := os.MkdirAll(put.Path, 0700)
+		if err != nil {
+			return err
+		}
+
+	} else if is_link {
+		// Create the link (replacing any file).
+		err := os.MkdirAll(path.Dir(put.Path), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(put.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		err = os.Symlink(put.Link, put.Path)
+		if err != nil {
+			return err
+		}
+
+	} else {
+		// Open the file.
+		flags := os.O_WRONLY | os.O_CREATE
+		if put.Offset == 0 {
+			flags |= os.O_TRUNC
+			err := os.MkdirAll(path.Dir(put.Path), 0755)
+			if err != nil {
+				return err
+			}
+		}
+		file, err := os.OpenFile(put.Path, flags, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		// Write the chunk.
+		for len(put.Data) > 0 {
+			n, err := file.WriteAt(put.Data, put.Offset)
+			result.Written += n
+			put.Offset += int64(n)
+			put.Data = put.Data[n:]
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !put.Done {
+		return nil
+	}
+
+	// Apply all metadata.
+	// Links have no mode of their own, and we
+	// don't want to touch whatever they point to.
+	if is_link {
+		if put.Uid != -1 || put.Gid != -1 {
+			return syscall.Lchown(put.Path, put.Uid, put.Gid)
+		}
+		return nil
+	}
+	if put.Mode != 0 {
+		err := syscall.Chmod(put.Path, put.Mode&07777)
+		if err != nil {
+			return err
+		}
+	}
+	if put.Uid != -1 || put.Gid != -1 {
+		err := syscall.Chown(put.Path, put.Uid, put.Gid)
+		if err != nil {
+			return err
+		}
+	}
+	if put.Mtime != 0 {
+		mtime := time.Unix(0, put.Mtime)
+		err := os.Chtimes(put.Path, mtime, mtime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type GetFileCommand struct {
+
+	// The file path.
+	Path string `json:"path"`
+
+	// Where to read from.
+	Offset int64 `json:"offset"`
+
+	// How much to read?
+	N uint `json:"n"`
+}
+
+type GetFileResult struct {
+
+	// The mode (including the type bits).
+	Mode uint32 `json:"mode"`
+
+	// The owner.
+	Uid int `json:"uid"`
+	Gid int `json:"gid"`
+
+	// The modification time (ns since the epoch).
+	Mtime int64 `json:"mtime"`
+
+	// The size of the file.
+	// Note that this may be zero for files
+	// in /proc and /sys, which still have data.
+	Size int64 `json:"size"`
+
+	// The data read.
+	// This is empty at the end of the file.
+	Data []byte `json:"data"`
+
+	// Directory entries (for a directory).
+	Entries []string `json:"entries"`
+
+	// The link target (for a symlink).
+	Link string `json:"link"`
+}
+
+func (server *Server) GetFile(
+	get *GetFileCommand,
+	result *GetFileResult) error {
+
+	// NOTE: We never follow links here. Otherwise
+	// anything walking the tree (i.e. the copy stream)
+	// could loop forever (for example, in /sys).
+	var stat syscall.Stat_t
+	err := syscall.Lstat(get.Path, &stat)
+	if err != nil {
+		return err
+	}
+
+	result.Mode = stat.Mode
+	result.Uid = int(stat.Uid)
+	result.Gid = int(stat.Gid)
+	result.Mtime = time.Unix(stat.Mtim.Sec, stat.Mtim.Nsec).UnixNano()
+	result.Size = stat.Size
+	result.Data = []byte{}
+
+	file_type := stat.Mode & syscall.S_IFMT
+	if file_type == syscall.S_IFLNK {
+		result.Link, err = os.Readlink(get.Path)
+		return err
+	}
+
+	// We only read directories and regular files.
+	// Anything else (e.g. a fifo) could block forever.
+	if file_type != syscall.S_IFDIR && file_type != syscall.S_IFREG {
+		return nil
+	}
+
+	file, err := os.Open(get.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if file_type == syscall.S_IFDIR {
+		// Read all entries.
+		result.Entries, err = file.Readdirnames(-1)
+		return err
+	}
+
+	// Read the chunk.
+	n := get.N
+	if n > FileChunkSize {
+		n = FileChunkSize
+	}
+	data := make([]byte, n, n)
+	length, err := file.ReadAt(data, get.Offset)
+	result.Data = data[:length]
+	if err == io.EOF {
+		err = nil
+	}
+
+	return err
+}
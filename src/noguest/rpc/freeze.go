@@ -0,0 +1,166 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Filesystem freeze ioctls (see linux/fs.h).
+const (
+	FIFREEZE = 0xc0045877
+	FITHAW   = 0xc0045878
+)
+
+type FreezeCommand struct {
+
+	// The mount points to freeze.
+	// If this is empty, we freeze all block-backed
+	// filesystems (i.e. those with a /dev source).
+	Paths []string `json:"paths"`
+}
+
+type FreezeResult struct {
+
+	// The mount points frozen.
+	Frozen []string `json:"frozen"`
+}
+
+func fsIoctl(path string, request uintptr) error {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, _, e := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		file.Fd(),
+		request,
+		0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+func blockMounts() ([]string, error) {
+
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(mounts))
+	for _, mount := range mounts {
+		if strings.HasPrefix(mount.Device, "/dev/") {
+			paths = append(paths, mount.Path)
+		}
+	}
+
+	return paths, nil
+}
+
+func (server *Server) Freeze(
+	freeze *FreezeCommand,
+	result *FreezeResult) error {
+
+	paths := freeze.Paths
+	if len(paths) == 0 {
+		var err error
+		paths, err = blockMounts()
+		if err != nil {
+			return err
+		}
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	// Flush everything first.
+	syscall.Sync()
+
+	result.Frozen = make([]string, 0, len(paths))
+	for _, path := range paths {
+		if server.frozen[path] {
+			continue
+		}
+		err := fsIoctl(path, FIFREEZE)
+		if err != nil {
+			// Don't leave anything half done.
+			for i := len(result.Frozen) - 1; i >= 0; i -= 1 {
+				fsIoctl(result.Frozen[i], FITHAW)
+				delete(server.frozen, result.Frozen[i])
+			}
+			result.Frozen = nil
+			return err
+		}
+		server.frozen[path] = true
+		result.Frozen = append(result.Frozen, path)
+	}
+
+	return nil
+}
+
+type ThawCommand struct {
+
+	// The mount points to thaw.
+	// If this is empty, we thaw everything frozen.
+	Paths []string `json:"paths"`
+}
+
+type ThawResult struct {
+
+	// The mount points thawed.
+	Thawed []string `json:"thawed"`
+}
+
+func (server *Server) Thaw(
+	thaw *ThawCommand,
+	result *ThawResult) error {
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	paths := thaw.Paths
+	if len(paths) == 0 {
+		paths = make([]string, 0, len(server.frozen))
+		for path := range server.frozen {
+			paths = append(paths, path)
+		}
+	}
+
+	// Thaw everything we can.
+	// We return the first error (if any).
+	var first_err error
+	result.Thawed = make([]string, 0, len(paths))
+	for _, path := range paths {
+		err := fsIoctl(path, FITHAW)
+		if err != nil && err != syscall.EINVAL {
+			// EINVAL means it wasn't frozen.
+			if first_err == nil {
+				first_err = err
+			}
+			continue
+		}
+		delete(server.frozen, path)
+		result.Thawed = append(result.Thawed, path)
+	}
+
+	return first_err
+}
@@ -0,0 +1,42 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"syscall"
+)
+
+type HeartbeatCommand struct {
+}
+
+type HeartbeatResult struct {
+
+	// Uptime (in seconds).
+	Uptime int64 `json:"uptime"`
+}
+
+func (server *Server) Heartbeat(
+	heartbeat *HeartbeatCommand,
+	result *HeartbeatResult) error {
+
+	var sysinfo syscall.Sysinfo_t
+	err := syscall.Sysinfo(&sysinfo)
+	if err != nil {
+		return err
+	}
+
+	result.Uptime = int64(sysinfo.Uptime)
+	return nil
+}
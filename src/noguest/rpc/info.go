@@ -0,0 +1,194 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"bufio"
+	"net"
+	"noguest/protocol"
+	"os"
+	"strings"
+	"syscall"
+)
+
+type InfoCommand struct {
+}
+
+type InfoMemory struct {
+
+	// All sizes in bytes.
+	Total     uint64 `json:"total"`
+	Free      uint64 `json:"free"`
+	Shared    uint64 `json:"shared"`
+	Buffers   uint64 `json:"buffers"`
+	SwapTotal uint64 `json:"swap-total"`
+	SwapFree  uint64 `json:"swap-free"`
+}
+
+type InfoMount struct {
+	Device  string `json:"device"`
+	Path    string `json:"path"`
+	Type    string `json:"type"`
+	Options string `json:"options"`
+}
+
+type InfoInterface struct {
+	Name      string   `json:"name"`
+	Mac       string   `json:"mac"`
+	Mtu       int      `json:"mtu"`
+	Up        bool     `json:"up"`
+	Addresses []string `json:"addresses"`
+}
+
+type InfoResult struct {
+
+	// The agent version.
+	Version string `json:"version"`
+
+	// The kernel (as per uname).
+	Kernel        string `json:"kernel"`
+	KernelVersion string `json:"kernel-version"`
+	Machine       string `json:"machine"`
+
+	// Uptime (in seconds).
+	Uptime int64 `json:"uptime"`
+
+	// Load averages (1, 5 and 15 minutes).
+	Load []float64 `json:"load"`
+
+	// The number of processes.
+	Processes int `json:"processes"`
+
+	// Memory totals.
+	Memory InfoMemory `json:"memory"`
+
+	// Mounted filesystems.
+	Mounts []InfoMount `json:"mounts"`
+
+	// Network interfaces.
+	Interfaces []InfoInterface `json:"interfaces"`
+}
+
+func utsString(value [65]int8) string {
+	data := make([]byte, 0, len(value))
+	for _, c := range value {
+		if c == 0 {
+			break
+		}
+		data = append(data, byte(c))
+	}
+	return string(data)
+}
+
+func readMounts() ([]InfoMount, error) {
+
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mounts := make([]InfoMount, 0, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		mounts = append(mounts, InfoMount{
+			Device:  fields[0],
+			Path:    fields[1],
+			Type:    fields[2],
+			Options: fields[3],
+		})
+	}
+
+	return mounts, scanner.Err()
+}
+
+func readInterfaces() ([]InfoInterface, error) {
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make([]InfoInterface, 0, len(ifaces))
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		addresses := make([]string, 0, len(addrs))
+		for _, addr := range addrs {
+			addresses = append(addresses, addr.String())
+		}
+		interfaces = append(interfaces, InfoInterface{
+			Name:      iface.Name,
+			Mac:       iface.HardwareAddr.String(),
+			Mtu:       iface.MTU,
+			Up:        iface.Flags&net.FlagUp != 0,
+			Addresses: addresses,
+		})
+	}
+
+	return interfaces, nil
+}
+
+func (server *Server) Info(
+	info *InfoCommand,
+	result *InfoResult) error {
+
+	result.Version = protocol.Version
+
+	var uts syscall.Utsname
+	err := syscall.Uname(&uts)
+	if err != nil {
+		return err
+	}
+	result.Kernel = utsString(uts.Release)
+	result.KernelVersion = utsString(uts.Version)
+	result.Machine = utsString(uts.Machine)
+
+	var sysinfo syscall.Sysinfo_t
+	err = syscall.Sysinfo(&sysinfo)
+	if err != nil {
+		return err
+	}
+	result.Uptime = int64(sysinfo.Uptime)
+	result.Load = make([]float64, 3, 3)
+	for i := 0; i < 3; i += 1 {
+		// Loads are fixed point (see sysinfo(2)).
+		result.Load[i] = float64(sysinfo.Loads[i]) / 65536.0
+	}
+	result.Processes = int(sysinfo.Procs)
+	unit := uint64(sysinfo.Unit)
+	result.Memory = InfoMemory{
+		Total:     uint64(sysinfo.Totalram) * unit,
+		Free:      uint64(sysinfo.Freeram) * unit,
+		Shared:    uint64(sysinfo.Sharedram) * unit,
+		Buffers:   uint64(sysinfo.Bufferram) * unit,
+		SwapTotal: uint64(sysinfo.Totalswap) * unit,
+		SwapFree:  uint64(sysinfo.Freeswap) * unit,
+	}
+
+	result.Mounts, err = readMounts()
+	if err != nil {
+		return err
+	}
+
+	result.Interfaces, err = readInterfaces()
+	return err
+}
@@ -0,0 +1,59 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"syscall"
+)
+
+type KillCommand struct {
+
+	// The relevant pid.
+	Pid int `json:"pid"`
+
+	// The signal to send.
+	Signal int `json:"signal"`
+
+	// Signal the whole process group?
+	// Processes are started in their own session,
+	// so this will include any of their children.
+	Group bool `json:"group"`
+}
+
+type KillResult struct {
+}
+
+func (server *Server) Kill(
+	kill *KillCommand,
+	result *KillResult) error {
+
+	// We only signal our own processes.
+	// Note that the pid may be reused once
+	// the process has exited and been reaped.
+	process := server.lookup(kill.Pid)
+	if process == nil {
+		return syscall.ESRCH
+	}
+
+	// The group may outlive the process itself
+	// (and its id won't be reused while it does).
+	if kill.Group {
+		return syscall.Kill(-kill.Pid, syscall.Signal(kill.Signal))
+	}
+	if exited, _, _ := process.status(); exited {
+		return syscall.ESRCH
+	}
+	return syscall.Kill(kill.Pid, syscall.Signal(kill.Signal))
+}
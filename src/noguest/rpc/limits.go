@@ -0,0 +1,175 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"encoding/json"
+	"os"
+	"syscall"
+)
+
+// The flag used to re-execute ourselves as a helper.
+// See childSetup below for the details.
+const ExecFlag = "exec"
+
+// The value used for an unlimited rlimit.
+const RlimitInfinity = ^uint64(0)
+
+type Rlimit struct {
+
+	// The soft limit.
+	Soft uint64 `json:"soft"`
+
+	// The hard limit.
+	Hard uint64 `json:"hard"`
+}
+
+// The rlimits we support.
+var rlimitResources = map[string]int{
+	"as":      syscall.RLIMIT_AS,
+	"core":    syscall.RLIMIT_CORE,
+	"cpu":     syscall.RLIMIT_CPU,
+	"data":    syscall.RLIMIT_DATA,
+	"fsize":   syscall.RLIMIT_FSIZE,
+	"memlock": 0x8, // RLIMIT_MEMLOCK
+	"nofile":  syscall.RLIMIT_NOFILE,
+	"nproc":   0x6, // RLIMIT_NPROC
+	"stack":   syscall.RLIMIT_STACK,
+}
+
+//
+// childSetup --
+//
+// Setup which must happen in the child itself.
+//
+// The Go runtime doesn't let us run arbitrary code
+// between fork() and exec(), and setting these in our
+// own process would affect noguest itself. So instead,
+// we run ourselves again as a small helper (still as
+// root), which sets the umask and rlimits, drops to the
+// given credentials and then execs the real binary.
+//
+// NOTE: The credentials must be applied last, as an
+// unprivileged process is not able to raise its limits.
+//
+type childSetup struct {
+
+	// The umask (if set).
+	Umask *uint32 `json:"umask,omitempty"`
+
+	// The rlimits.
+	Rlimits map[string]Rlimit `json:"rlimits,omitempty"`
+
+	// The credentials (if set).
+	Credential *childCredential `json:"credential,omitempty"`
+}
+
+type childCredential struct {
+	Uid    uint32   `json:"uid"`
+	Gid    uint32   `json:"gid"`
+	Groups []uint32 `json:"groups"`
+}
+
+func (setup *childSetup) empty() bool {
+	return setup.Umask == nil && len(setup.Rlimits) == 0
+}
+
+func (setup *childSetup) validate() error {
+	for name, limit := range setup.Rlimits {
+		if _, ok := rlimitResources[name]; !ok {
+			return syscall.EINVAL
+		}
+		if limit.Soft > limit.Hard {
+			return syscall.EINVAL
+		}
+	}
+	return nil
+}
+
+func (setup *childSetup) args(binary string, args []string) ([]string, error) {
+
+	spec, err := json.Marshal(setup)
+	if err != nil {
+		return nil, err
+	}
+
+	new_args := make([]string, 0, len(args)+5)
+	new_args = append(new_args, "noguest")
+	new_args = append(new_args, "-"+ExecFlag, string(spec), "--")
+	new_args = append(new_args, binary)
+	new_args = append(new_args, args...)
+	return new_args, nil
+}
+
+//
+// Exec --
+//
+// Run as the helper described above.
+//
+// The spec is the encoded childSetup, and args
+// is the binary followed by the full argv. This
+// will only return if something goes wrong.
+//
+func Exec(spec string, args []string) error {
+
+	if len(args) < 2 {
+		return syscall.EINVAL
+	}
+
+	var setup childSetup
+	err := json.Unmarshal([]byte(spec), &setup)
+	if err != nil {
+		return err
+	}
+
+	if setup.Umask != nil {
+		syscall.Umask(int(*setup.Umask))
+	}
+
+	for name, limit := range setup.Rlimits {
+		resource, ok := rlimitResources[name]
+		if !ok {
+			return syscall.EINVAL
+		}
+		err = syscall.Setrlimit(resource, &syscall.Rlimit{
+			Cur: limit.Soft,
+			Max: limit.Hard,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if setup.Credential != nil {
+		groups := make([]int, 0, len(setup.Credential.Groups))
+		for _, group := range setup.Credential.Groups {
+			groups = append(groups, int(group))
+		}
+		err = syscall.Setgroups(groups)
+		if err != nil {
+			return err
+		}
+		err = syscall.Setgid(int(setup.Credential.Gid))
+		if err != nil {
+			return err
+		}
+		err = syscall.Setuid(int(setup.Credential.Uid))
+		if err != nil {
+			return err
+		}
+	}
+
+	return syscall.Exec(args[0], args[1:], os.Environ())
+}
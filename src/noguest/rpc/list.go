@@ -0,0 +1,85 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"sort"
+	"time"
+)
+
+// Process states.
+const (
+	ProcessRunning = "running"
+	ProcessExited  = "exited"
+)
+
+type ListCommand struct {
+}
+
+type ProcessInfo struct {
+
+	// The pid.
+	Pid int `json:"pid"`
+
+	// The command (as started).
+	Command []string `json:"command"`
+
+	// The start time.
+	Start time.Time `json:"start"`
+
+	// The state (above).
+	State string `json:"state"`
+
+	// The exit code and time (if exited).
+	Exitcode int        `json:"exitcode"`
+	Exit     *time.Time `json:"exit,omitempty"`
+}
+
+type ListResult struct {
+
+	// All known processes (by pid).
+	Processes []ProcessInfo `json:"processes"`
+}
+
+func (server *Server) List(
+	list *ListCommand,
+	result *ListResult) error {
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	result.Processes = make([]ProcessInfo, 0, len(server.active))
+	for pid, process := range server.active {
+		info := ProcessInfo{
+			Pid:     pid,
+			Command: process.command,
+			Start:   process.starttime,
+			State:   ProcessRunning,
+		}
+		exited, exitcode, exittime := process.status()
+		if exited {
+			info.State = ProcessExited
+			info.Exitcode = exitcode
+			info.Exit = &exittime
+		}
+		result.Processes = append(result.Processes, info)
+	}
+
+	sort.Slice(result.Processes, func(i int, j int) bool {
+		return result.Processes[i].Pid < result.Processes[j].Pid
+	})
+
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Defaults for 9p mounts.
+const (
+	Mount9pTrans   = "virtio"
+	Mount9pVersion = "9p2000.L"
+)
+
+// Supported mount flags.
+var mountFlags = map[string]uintptr{
+	"ro":      syscall.MS_RDONLY,
+	"nosuid":  syscall.MS_NOSUID,
+	"nodev":   syscall.MS_NODEV,
+	"noexec":  syscall.MS_NOEXEC,
+	"noatime": syscall.MS_NOATIME,
+	"sync":    syscall.MS_SYNCHRONOUS,
+	"bind":    syscall.MS_BIND,
+	"rec":     syscall.MS_REC,
+}
+
+type MountCommand struct {
+
+	// The source.
+	// For 9p, this is the VirtioFsDevice tag.
+	Source string `json:"source"`
+
+	// Where to mount it.
+	// This will be created if necessary.
+	Target string `json:"target"`
+
+	// The filesystem type (by default, 9p).
+	Type string `json:"type"`
+
+	// Mount flags (e.g. "ro", "nosuid", "nodev").
+	Flags []string `json:"flags"`
+
+	// Other filesystem-specific options.
+	Options string `json:"options"`
+
+	// 9p options.
+	// If not set, these have sensible defaults
+	// for a VirtioFsDevice (and msize is left
+	// to the kernel).
+	Trans   string `json:"trans"`
+	Version string `json:"version"`
+	Msize   int    `json:"msize"`
+}
+
+type MountResult struct {
+}
+
+func (server *Server) Mount(
+	mount *MountCommand,
+	result *MountResult) error {
+
+	if mount.Source == "" || mount.Target == "" {
+		return syscall.EINVAL
+	}
+
+	fstype := mount.Type
+	if fstype == "" {
+		fstype = "9p"
+	}
+
+	var flags uintptr
+	for _, flag := range mount.Flags {
+		value, ok := mountFlags[flag]
+		if !ok {
+			return syscall.EINVAL
+		}
+		flags |= value
+	}
+
+	options := make([]string, 0, 4)
+	if fstype == "9p" {
+		trans := mount.Trans
+		if trans == "" {
+			trans = Mount9pTrans
+		}
+		version := mount.Version
+		if version == "" {
+			version = Mount9pVersion
+		}
+		options = append(options, "trans="+trans, "version="+version)
+		if mount.Msize > 0 {
+			options = append(options, fmt.Sprintf("msize=%d", mount.Msize))
+		}
+	}
+	if mount.Options != "" {
+		options = append(options, mount.Options)
+	}
+
+	// Make sure we have the target.
+	_, err := os.Stat(mount.Target)
+	if err != nil {
+		err = os.MkdirAll(mount.Target, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	return syscall.Mount(
+		mount.Source,
+		mount.Target,
+		fstype,
+		flags,
+		strings.Join(options, ","))
+}
+
+type UnmountCommand struct {
+
+	// The mount point.
+	Target string `json:"target"`
+
+	// Detach now, and cleanup when no longer busy?
+	Lazy bool `json:"lazy"`
+
+	// Force the unmount?
+	Force bool `json:"force"`
+}
+
+type UnmountResult struct {
+}
+
+func (server *Server) Unmount(
+	unmount *UnmountCommand,
+	result *UnmountResult) error {
+
+	var flags int
+	if unmount.Lazy {
+		flags |= syscall.MNT_DETACH
+	}
+	if unmount.Force {
+		flags |= syscall.MNT_FORCE
+	}
+
+	return syscall.Unmount(unmount.Target, flags)
+}
@@ -0,0 +1,213 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+)
+
+//
+// Netlink messages are in host byte order.
+// (We only support x86, so this is little endian.)
+//
+var nativeEndian = binary.LittleEndian
+
+type netlink struct {
+	fd  int
+	seq uint32
+}
+
+func newNetlink() (*netlink, error) {
+
+	fd, err := syscall.Socket(
+		syscall.AF_NETLINK,
+		syscall.SOCK_RAW|syscall.SOCK_CLOEXEC,
+		syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return &netlink{fd: fd}, nil
+}
+
+func (nl *netlink) close() {
+	syscall.Close(nl.fd)
+}
+
+func netlinkAttr(attr_type uint16, data []byte) []byte {
+	length := syscall.SizeofRtAttr + len(data)
+	attr := make([]byte, (length+3)&^3)
+	nativeEndian.PutUint16(attr[0:2], uint16(length))
+	nativeEndian.PutUint16(attr[2:4], attr_type)
+	copy(attr[syscall.SizeofRtAttr:], data)
+	return attr
+}
+
+func netlinkUint32(value uint32) []byte {
+	data := make([]byte, 4, 4)
+	nativeEndian.PutUint32(data, value)
+	return data
+}
+
+//
+// request --
+//
+// Send a single request and wait for the ack.
+//
+func (nl *netlink) request(
+	msg_type uint16,
+	flags uint16,
+	body []byte,
+	attrs ...[]byte) error {
+
+	nl.seq += 1
+	msg := make([]byte, syscall.SizeofNlMsghdr, 256)
+	msg = append(msg, body...)
+	for _, attr := range attrs {
+		msg = append(msg, attr...)
+	}
+	nativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+	nativeEndian.PutUint16(msg[4:6], msg_type)
+	nativeEndian.PutUint16(msg[6:8], flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	nativeEndian.PutUint32(msg[8:12], nl.seq)
+	nativeEndian.PutUint32(msg[12:16], 0)
+
+	err := syscall.Sendto(nl.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]byte, 8192, 8192)
+	for {
+		n, _, err := syscall.Recvfrom(nl.fd, buffer, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buffer[:n])
+		if err != nil {
+			return err
+		}
+		for _, msg := range msgs {
+			if msg.Header.Seq != nl.seq ||
+				msg.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if len(msg.Data) < 4 {
+				return syscall.EINVAL
+			}
+			errno := int32(nativeEndian.Uint32(msg.Data[0:4]))
+			if errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+func (nl *netlink) setLink(index int, up bool, mtu int) error {
+
+	// struct ifinfomsg.
+	body := make([]byte, syscall.SizeofIfInfomsg, syscall.SizeofIfInfomsg)
+	body[0] = syscall.AF_UNSPEC
+	nativeEndian.PutUint32(body[4:8], uint32(index))
+	if up {
+		nativeEndian.PutUint32(body[8:12], syscall.IFF_UP)
+	}
+	nativeEndian.PutUint32(body[12:16], syscall.IFF_UP)
+
+	attrs := make([][]byte, 0, 1)
+	if mtu > 0 {
+		attrs = append(attrs, netlinkAttr(syscall.IFLA_MTU, netlinkUint32(uint32(mtu))))
+	}
+
+	return nl.request(syscall.RTM_NEWLINK, 0, body, attrs...)
+}
+
+func ipFamily(ip net.IP) (uint8, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return syscall.AF_INET, ip4
+	}
+	return syscall.AF_INET6, ip.To16()
+}
+
+func (nl *netlink) addAddress(index int, ip net.IP, prefix int) error {
+
+	family, ip := ipFamily(ip)
+
+	// struct ifaddrmsg.
+	body := make([]byte, syscall.SizeofIfAddrmsg, syscall.SizeofIfAddrmsg)
+	body[0] = family
+	body[1] = uint8(prefix)
+	body[3] = syscall.RT_SCOPE_UNIVERSE
+	nativeEndian.PutUint32(body[4:8], uint32(index))
+
+	return nl.request(
+		syscall.RTM_NEWADDR,
+		syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE,
+		body,
+		netlinkAttr(syscall.IFA_LOCAL, ip),
+		netlinkAttr(syscall.IFA_ADDRESS, ip))
+}
+
+func (nl *netlink) addRoute(dst *net.IPNet, gateway net.IP, index int) error {
+
+	var family uint8
+	var prefix int
+	attrs := make([][]byte, 0, 3)
+	if dst != nil {
+		var ip net.IP
+		family, ip = ipFamily(dst.IP)
+		prefix, _ = dst.Mask.Size()
+		attrs = append(attrs, netlinkAttr(syscall.RTA_DST, ip))
+	}
+	if gateway != nil {
+		var ip net.IP
+		family, ip = ipFamily(gateway)
+		attrs = append(attrs, netlinkAttr(syscall.RTA_GATEWAY, ip))
+	}
+	if index > 0 {
+		attrs = append(attrs, netlinkAttr(syscall.RTA_OIF, netlinkUint32(uint32(index))))
+	}
+	if family == 0 {
+		return syscall.EINVAL
+	}
+
+	// struct rtmsg.
+	body := make([]byte, syscall.SizeofRtMsg, syscall.SizeofRtMsg)
+	body[0] = family
+	body[1] = uint8(prefix)
+	body[4] = syscall.RT_TABLE_MAIN
+	body[5] = syscall.RTPROT_BOOT
+	if gateway != nil {
+		body[6] = syscall.RT_SCOPE_UNIVERSE
+	} else {
+		body[6] = syscall.RT_SCOPE_LINK
+	}
+	body[7] = syscall.RTN_UNICAST
+
+	return nl.request(
+		syscall.RTM_NEWROUTE,
+		syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE,
+		body,
+		attrs...)
+}
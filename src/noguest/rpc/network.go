@@ -0,0 +1,250 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The resolver configuration.
+const ResolvConf = "/etc/resolv.conf"
+
+type NetworkInterface struct {
+
+	// The interface, by MAC (preferred) or name.
+	// The MAC should match the VirtioNetDevice.
+	Mac  string `json:"mac"`
+	Name string `json:"name"`
+
+	// Addresses (in CIDR notation).
+	Addresses []string `json:"addresses"`
+
+	// The MTU (if not set, unchanged).
+	Mtu int `json:"mtu"`
+
+	// Leave the interface down?
+	Down bool `json:"down"`
+}
+
+type NetworkRoute struct {
+
+	// The destination (in CIDR notation).
+	// If this is empty or "default", then
+	// this is the default route.
+	Destination string `json:"destination"`
+
+	// The gateway (if any).
+	Gateway string `json:"gateway"`
+
+	// The interface, by MAC or name (optional).
+	Mac  string `json:"mac"`
+	Name string `json:"name"`
+}
+
+type NetworkCommand struct {
+
+	// Interfaces to configure.
+	Interfaces []NetworkInterface `json:"interfaces"`
+
+	// Routes to add.
+	Routes []NetworkRoute `json:"routes"`
+
+	// DNS configuration.
+	// If nameservers are given, then
+	// ResolvConf will be replaced.
+	Nameservers []string `json:"nameservers"`
+	Search      []string `json:"search"`
+}
+
+type NetworkResult struct {
+
+	// The names of configured interfaces.
+	Interfaces []string `json:"interfaces"`
+}
+
+func findInterface(mac string, name string) (*net.Interface, error) {
+
+	if mac == "" {
+		if name == "" {
+			return nil, nil
+		}
+		return net.InterfaceByName(name)
+	}
+
+	hwaddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i, iface := range ifaces {
+		if bytes.Equal(iface.HardwareAddr, hwaddr) {
+			return &ifaces[i], nil
+		}
+	}
+
+	return nil, syscall.ENODEV
+}
+
+func (server *Server) ConfigureNetwork(
+	command *NetworkCommand,
+	result *NetworkResult) error {
+
+	nl, err := newNetlink()
+	if err != nil {
+		return err
+	}
+	defer nl.close()
+
+	// Always bring up the loopback.
+	lo, err := net.InterfaceByName("lo")
+	if err == nil {
+		err = nl.setLink(lo.Index, true, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	result.Interfaces = make([]string, 0, len(command.Interfaces))
+	for _, config := range command.Interfaces {
+		iface, err := findInterface(config.Mac, config.Name)
+		if err != nil {
+			return err
+		} else if iface == nil {
+			return syscall.EINVAL
+		}
+
+		err = nl.setLink(iface.Index, !config.Down, config.Mtu)
+		if err != nil {
+			return err
+		}
+
+		for _, address := range config.Addresses {
+			ip, ipnet, err := net.ParseCIDR(address)
+			if err != nil {
+				return err
+			}
+			prefix, _ := ipnet.Mask.Size()
+			err = nl.addAddress(iface.Index, ip, prefix)
+			if err != nil {
+				return err
+			}
+		}
+
+		result.Interfaces = append(result.Interfaces, iface.Name)
+	}
+
+	for _, route := range command.Routes {
+		var dst *net.IPNet
+		if route.Destination != "" && route.Destination != "default" {
+			_, dst, err = net.ParseCIDR(route.Destination)
+			if err != nil {
+				return err
+			}
+		}
+
+		var gateway net.IP
+		if route.Gateway != "" {
+			gateway = net.ParseIP(route.Gateway)
+			if gateway == nil {
+				return syscall.EINVAL
+			}
+		}
+
+		var index int
+		iface, err := findInterface(route.Mac, route.Name)
+		if err != nil {
+			return err
+		} else if iface != nil {
+			index = iface.Index
+		}
+
+		err = nl.addRoute(dst, gateway, index)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(command.Nameservers) > 0 {
+		return server.writeResolvConf(command.Nameservers, command.Search)
+	}
+
+	return nil
+}
+
+//
+// writeResolvConf --
+//
+// The guest filesystem is generally shared with the host,
+// so we never write ResolvConf itself. Instead, we bind an
+// anonymous (memfd) file over the top of it.
+//
+func (server *Server) writeResolvConf(nameservers []string, search []string) error {
+
+	var data bytes.Buffer
+	if len(search) > 0 {
+		fmt.Fprintf(&data, "search %s\n", strings.Join(search, " "))
+	}
+	for _, nameserver := range nameservers {
+		if net.ParseIP(nameserver) == nil {
+			return syscall.EINVAL
+		}
+		fmt.Fprintf(&data, "nameserver %s\n", nameserver)
+	}
+
+	name := []byte("resolv.conf\x00")
+	fd, _, e := syscall.Syscall(
+		sysMemfdCreate,
+		uintptr(unsafe.Pointer(&name[0])),
+		0,
+		0)
+	if e != 0 {
+		return e
+	}
+	defer syscall.Close(int(fd))
+
+	_, err := syscall.Write(int(fd), data.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// Replace any previous mount.
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.resolv_mounted {
+		syscall.Unmount(ResolvConf, syscall.MNT_DETACH)
+		server.resolv_mounted = false
+	}
+
+	err = syscall.Mount(
+		fmt.Sprintf("/proc/self/fd/%d", fd),
+		ResolvConf,
+		"",
+		syscall.MS_BIND,
+		"")
+	if err != nil {
+		return err
+	}
+
+	server.resolv_mounted = true
+	return nil
+}
@@ -14,6 +14,19 @@
 
 package rpc
 
+import (
+	"io"
+)
+
+// Our output streams.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// The most we will read at once.
+const ReadChunkSize = 4096
+
 type ReadCommand struct {
 
 	// The relevant pid.
@@ -27,6 +40,10 @@ type ReadResult struct {
 
 	// The data read.
 	Data []byte `json:"data"`
+
+	// Which stream was this?
+	// For a terminal, this is always stdout.
+	Stream string `json:"stream"`
 }
 
 func (server *Server) Read(
@@ -39,14 +56,42 @@ func (server *Server) Read(
 		return nil
 	}
 
+	var err error
+	*result, err = process.read(read.N)
+	return err
+}
+
+func (process *Process) read(n uint) (ReadResult, error) {
+
+	process.read_mu.Lock()
+	defer process.read_mu.Unlock()
+
 	// Read available data.
-	buffer := make([]byte, read.N, read.N)
-	n, err := process.output.Read(buffer)
-	if n > 0 {
-		result.Data = buffer[:n]
-	} else {
-		result.Data = []byte{}
+	// We first finish anything that we had
+	// left over from a previous short read.
+	chunk := process.leftover
+	if len(chunk.Data) == 0 {
+		var ok bool
+		chunk, ok = <-process.reads
+		if !ok {
+			return ReadResult{
+				Data:   []byte{},
+				Stream: StreamStdout,
+			}, io.EOF
+		}
 	}
 
-	return err
+	// Save whatever doesn't fit.
+	if uint(len(chunk.Data)) < n {
+		n = uint(len(chunk.Data))
+	}
+	process.leftover = ReadResult{
+		Data:   chunk.Data[n:],
+		Stream: chunk.Stream,
+	}
+
+	return ReadResult{
+		Data:   chunk.Data[:n],
+		Stream: chunk.Stream,
+	}, nil
 }
@@ -0,0 +1,92 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"syscall"
+	"time"
+)
+
+type ReleaseCommand struct {
+
+	// The relevant pid.
+	Pid int `json:"pid"`
+}
+
+type ReleaseResult struct {
+}
+
+//
+// Release --
+//
+// Forget about an exited process (and any unread
+// output). Running processes must be killed first.
+//
+func (server *Server) Release(
+	release *ReleaseCommand,
+	result *ReleaseResult) error {
+
+	server.mutex.Lock()
+	process := server.active[release.Pid]
+	if process == nil {
+		server.mutex.Unlock()
+		return syscall.ESRCH
+	}
+	if exited, _, _ := process.status(); !exited {
+		server.mutex.Unlock()
+		return syscall.EBUSY
+	}
+	delete(server.active, release.Pid)
+	server.mutex.Unlock()
+
+	process.close()
+	return nil
+}
+
+type RetainCommand struct {
+
+	// How long to keep exited processes (seconds).
+	// If this is negative, exited processes are
+	// kept until they are explicitly released.
+	Retention int `json:"retention"`
+}
+
+type RetainResult struct {
+
+	// The previous retention (seconds).
+	Retention int `json:"retention"`
+}
+
+func (server *Server) Retain(
+	retain *RetainCommand,
+	result *RetainResult) error {
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.retention < 0 {
+		result.Retention = -1
+	} else {
+		result.Retention = int(server.retention / time.Second)
+	}
+
+	if retain.Retention < 0 {
+		server.retention = -1
+	} else {
+		server.retention = time.Duration(retain.Retention) * time.Second
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type ResizeCommand struct {
+
+	// The relevant pid.
+	Pid int `json:"pid"`
+
+	// The new terminal size.
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+type ResizeResult struct {
+}
+
+// See struct winsize in <termios.h>.
+type winsize struct {
+	rows   uint16
+	cols   uint16
+	xpixel uint16
+	ypixel uint16
+}
+
+func (server *Server) Resize(
+	resize *ResizeCommand,
+	result *ResizeResult) error {
+
+	process := server.lookup(resize.Pid)
+	if process == nil {
+		return syscall.ESRCH
+	}
+	if !process.terminal {
+		return syscall.ENOTTY
+	}
+
+	// Set the size on the master.
+	// The kernel will deliver a SIGWINCH to
+	// the foreground process group for us.
+	ws := winsize{rows: resize.Rows, cols: resize.Cols}
+	_, _, e := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		process.input.Fd(),
+		uintptr(syscall.TIOCSWINSZ),
+		uintptr(unsafe.Pointer(&ws)))
+	if e != 0 {
+		return e
+	}
+
+	return nil
+}
@@ -16,7 +16,7 @@ package rpc
 
 import (
 	"net/rpc"
-	"net/rpc/jsonrpc"
+	"noguest/protocol"
 	"os"
 	"sync"
 	"syscall"
@@ -25,9 +25,29 @@ import (
 
 type Process struct {
 
+	// The command (as started).
+	command []string
+
 	// The files.
+	// For a terminal, there is no separate
+	// errout (it's all part of the output).
 	input  *os.File
 	output *os.File
+	errout *os.File
+
+	// Is this a terminal?
+	// If so, input is the pty master.
+	terminal bool
+
+	// Our output, as it is read (see pump()).
+	// This is closed once all output is done.
+	reads    chan ReadResult
+	leftover ReadResult
+	read_mu  sync.Mutex
+
+	// Closed when the process is closed.
+	closed    chan bool
+	is_closed bool
 
 	// The start time.
 	starttime time.Time
@@ -39,8 +59,19 @@ type Process struct {
 	exited bool
 
 	// Our exitcode.
+	// If the process was killed by a signal,
+	// this is 128+signal (as per the shell).
 	exitcode int
 
+	// The terminating signal (if any).
+	signal int
+
+	// Was there a core dump?
+	core_dumped bool
+
+	// Resource usage (as per wait4).
+	rusage syscall.Rusage
+
 	cond *sync.Cond
 }
 
@@ -54,6 +85,12 @@ func (process *Process) wait() {
 	}
 }
 
+func (process *Process) status() (bool, int, time.Time) {
+	process.cond.L.Lock()
+	defer process.cond.L.Unlock()
+	return process.exited, process.exitcode, process.exittime
+}
+
 func (process *Process) setExitcode(exitcode int) {
 	process.cond.L.Lock()
 	defer process.cond.L.Unlock()
@@ -65,16 +102,87 @@ func (process *Process) setExitcode(exitcode int) {
 	process.cond.Broadcast()
 }
 
-func (process *Process) close() {
+func (process *Process) setStatus(
+	wstatus syscall.WaitStatus,
+	rusage *syscall.Rusage) {
+
 	process.cond.L.Lock()
 	defer process.cond.L.Unlock()
 
+	// Set the full status.
+	process.exited = true
+	if wstatus.Signaled() {
+		process.signal = int(wstatus.Signal())
+		process.exitcode = 128 + process.signal
+		process.core_dumped = wstatus.CoreDump()
+	} else {
+		process.exitcode = wstatus.ExitStatus()
+	}
+	process.rusage = *rusage
+	process.exittime = time.Now()
+	process.cond.Broadcast()
+}
+
+func (process *Process) pump(file *os.File, stream string, done *sync.WaitGroup) {
+	defer done.Done()
+
+	for {
+		buffer := make([]byte, ReadChunkSize, ReadChunkSize)
+		n, err := file.Read(buffer)
+		if n > 0 {
+			select {
+			case process.reads <- ReadResult{Data: buffer[:n], Stream: stream}:
+			case <-process.closed:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (process *Process) start() {
+
+	var done sync.WaitGroup
+
+	// Read all our outputs.
+	done.Add(1)
+	go process.pump(process.output, StreamStdout, &done)
+	if process.errout != nil {
+		done.Add(1)
+		go process.pump(process.errout, StreamStderr, &done)
+	}
+
+	// Note when we're finished.
+	go func() {
+		done.Wait()
+		close(process.reads)
+	}()
+}
+
+func (process *Process) close() {
+	process.cond.L.Lock()
+	if process.is_closed {
+		process.cond.L.Unlock()
+		return
+	}
+	process.is_closed = true
+	close(process.closed)
+	exited := process.exited
+	process.cond.L.Unlock()
+
 	// Simulate an exit.
-	process.setExitcode(1)
+	if !exited {
+		process.setExitcode(1)
+	}
 	process.input.Close()
 	if process.input != process.output {
 		process.output.Close()
 	}
+	if process.errout != nil {
+		process.errout.Close()
+	}
 }
 
 type Server struct {
@@ -82,22 +190,43 @@ type Server struct {
 	// Active processes.
 	active map[int]*Process
 
+	// How long we keep exited processes.
+	// If this is negative, we keep them until
+	// they are explicitly released.
+	retention time.Duration
+
+	// Frozen filesystems (see freeze.go).
+	frozen map[string]bool
+
+	// Is our ResolvConf mounted (see network.go)?
+	resolv_mounted bool
+
 	// Is wait running?
 	waiting bool
 
 	// Our lock protects
-	// access to the above map.
+	// access to the above.
 	mutex sync.Mutex
 }
 
+// The default retention.
+var DefaultRetention = time.Minute
+
+// How often we look for stale processes.
+var ClearInterval = 10 * time.Second
+
 func (server *Server) clearStale() {
 	server.mutex.Lock()
 	defer server.mutex.Unlock()
 
+	if server.retention < 0 {
+		return
+	}
+
 	for pid, process := range server.active {
-		// Has this exited more than a minute ago?
-		if process.exited &&
-			process.exittime.Sub(process.starttime) > time.Minute {
+		// Has this exited more than retention ago?
+		exited, _, exittime := process.status()
+		if exited && time.Since(exittime) > server.retention {
 			delete(server.active, pid)
 			process.close()
 		}
@@ -106,7 +235,7 @@ func (server *Server) clearStale() {
 
 func (server *Server) clearPeriodic() {
 	server.clearStale()
-	time.AfterFunc(time.Minute, server.clearPeriodic)
+	time.AfterFunc(ClearInterval, server.clearPeriodic)
 }
 
 func (server *Server) lookup(pid int) *Process {
@@ -147,10 +276,10 @@ func (server *Server) wait() {
 				continue
 			}
 		}
-		if wstatus.Exited() {
+		if wstatus.Exited() || wstatus.Signaled() {
 			process := server.lookup(pid)
 			if process != nil {
-				process.setExitcode(wstatus.ExitStatus())
+				process.setStatus(wstatus, &rusage)
 			}
 		}
 		if last_run {
@@ -164,18 +293,26 @@ func Run(file *os.File) {
 	// Create our server.
 	server := new(Server)
 	server.active = make(map[int]*Process)
+	server.retention = DefaultRetention
+	server.frozen = make(map[string]bool)
 
 	// Start our periodic clearer.
 	server.clearPeriodic()
 
 	// Create our RPC server.
-	codec := jsonrpc.NewServerCodec(file)
 	rpcserver := rpc.NewServer()
 	rpcserver.Register(server)
 
 	// Listen for children.
 	go server.wait()
 
-	// Service requests.
-	rpcserver.ServeCodec(codec)
+	// Service all streams.
+	session := protocol.NewSession(file, false)
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			break
+		}
+		go server.serve(stream, rpcserver)
+	}
 }
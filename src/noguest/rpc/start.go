@@ -46,6 +46,20 @@ type StartCommand struct {
 
 	// The environment.
 	Environment []string `json:"environment"`
+
+	// The user and group to run as.
+	// By default, this will run as root.
+	Uid uint32 `json:"uid"`
+	Gid uint32 `json:"gid"`
+
+	// Supplementary groups.
+	Groups []uint32 `json:"groups"`
+
+	// The umask (if not set, inherited).
+	Umask *uint32 `json:"umask,omitempty"`
+
+	// Resource limits (e.g. "nofile", "nproc", "core").
+	Rlimits map[string]Rlimit `json:"rlimits"`
 }
 
 type StartResult struct {
@@ -91,8 +105,49 @@ func (server *Server) Start(
 		return syscall.ENOENT
 	}
 
+	// Do we need to change credentials?
+	var credential *syscall.Credential
+	if command.Uid != 0 || command.Gid != 0 || len(command.Groups) > 0 {
+		credential = &syscall.Credential{
+			Uid:    command.Uid,
+			Gid:    command.Gid,
+			Groups: command.Groups,
+		}
+	}
+
+	// Do we need our helper?
+	// If so, it applies the credentials itself.
+	args := command.Command
+	setup := &childSetup{
+		Umask:   command.Umask,
+		Rlimits: command.Rlimits,
+	}
+	err = setup.validate()
+	if err != nil {
+		return err
+	}
+	if !setup.empty() {
+		if credential != nil {
+			setup.Credential = &childCredential{
+				Uid:    credential.Uid,
+				Gid:    credential.Gid,
+				Groups: credential.Groups,
+			}
+			credential = nil
+		}
+		args, err = setup.args(binary, command.Command)
+		if err != nil {
+			return err
+		}
+		binary, err = os.Executable()
+		if err != nil {
+			return err
+		}
+	}
+
 	var input *os.File
 	var output *os.File
+	var errout *os.File
 
 	var stdin *os.File
 	var stdout *os.File
@@ -170,15 +225,27 @@ func (server *Server) Start(
 			return err
 		}
 
+		r3, w3, err := os.Pipe()
+		if err != nil {
+			r1.Close()
+			w1.Close()
+			r2.Close()
+			w2.Close()
+			result.Pid = -1
+			return err
+		}
+
 		defer r1.Close()
 		defer w2.Close()
+		defer w3.Close()
 
 		// Set our inputs.
 		input = w1
 		output = r2
+		errout = r3
 		stdin = r1
 		stdout = w2
-		stderr = w2
+		stderr = w3
 	}
 
 	// Start the process.
@@ -187,38 +254,53 @@ func (server *Server) Start(
 		Env:   command.Environment,
 		Files: []*os.File{stdin, stdout, stderr},
 		Sys: &syscall.SysProcAttr{
-			Setsid:  true,
-			Setctty: command.Terminal,
-			Ctty:    0,
+			Setsid:     true,
+			Setctty:    command.Terminal,
+			Ctty:       0,
+			Credential: credential,
 		},
 	}
+
+	// We hold the lock until the process is saved.
+	// Otherwise, it may exit and be reaped before we
+	// know about it, and the exit status would be lost.
+	server.mutex.Lock()
 	proc, err := os.StartProcess(
 		binary,
-		command.Command,
+		args,
 		proc_attr)
 
 	// Unable to start?
 	if err != nil {
+		server.mutex.Unlock()
 		input.Close()
 		if input != output {
 			output.Close()
 		}
+		if errout != nil {
+			errout.Close()
+		}
 		result.Pid = -1
 		return err
 	}
 
 	// Create our process.
 	process := &Process{
+		command:   command.Command,
 		input:     input,
 		output:    output,
+		errout:    errout,
+		terminal:  command.Terminal,
+		reads:     make(chan ReadResult),
+		closed:    make(chan bool),
 		starttime: time.Now(),
 		cond:      sync.NewCond(&sync.Mutex{}),
 	}
+	process.start()
 
 	// Save the pid.
 	result.Pid = proc.Pid
 
-	server.mutex.Lock()
 	old_process := server.active[result.Pid]
 	server.active[result.Pid] = process
 	server.mutex.Unlock()
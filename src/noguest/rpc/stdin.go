@@ -0,0 +1,53 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"syscall"
+)
+
+type CloseStdinCommand struct {
+
+	// The relevant pid.
+	Pid int `json:"pid"`
+}
+
+type CloseStdinResult struct {
+}
+
+func (server *Server) CloseStdin(
+	command *CloseStdinCommand,
+	result *CloseStdinResult) error {
+
+	process := server.lookup(command.Pid)
+	if process == nil {
+		return syscall.ESRCH
+	}
+
+	return process.closeStdin()
+}
+
+func (process *Process) closeStdin() error {
+
+	// We can't close one side of a terminal.
+	// The best we can do is send an EOF (^D), which
+	// will be seen if the terminal is in canonical mode.
+	if process.terminal {
+		_, err := process.input.Write([]byte{4})
+		return err
+	}
+
+	return process.input.Close()
+}
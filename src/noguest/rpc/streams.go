@@ -0,0 +1,129 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"noguest/protocol"
+	"strconv"
+	"syscall"
+)
+
+func (server *Server) serve(stream *protocol.Stream, rpcserver *rpc.Server) {
+
+	kind, args := protocol.ParseStreamName(stream.Name())
+
+	switch kind {
+	case protocol.StreamRpc:
+		rpcserver.ServeCodec(jsonrpc.NewServerCodec(stream))
+
+	case protocol.StreamOutput:
+		process := server.streamProcess(stream, args)
+		if process != nil {
+			server.streamOutput(stream, process)
+		}
+
+	case protocol.StreamInput:
+		process := server.streamProcess(stream, args)
+		if process != nil {
+			server.streamInput(stream, process)
+		}
+
+	case protocol.StreamDial:
+		if len(args) != 2 {
+			stream.Reset(syscall.EINVAL)
+			return
+		}
+		conn, err := net.Dial(args[0], args[1])
+		if err != nil {
+			stream.Reset(err)
+			return
+		}
+		stream.Splice(conn)
+
+	default:
+		stream.Reset(protocol.UnknownCommand)
+	}
+}
+
+func (server *Server) streamProcess(
+	stream *protocol.Stream,
+	args []string) *Process {
+
+	if len(args) != 1 {
+		stream.Reset(syscall.EINVAL)
+		return nil
+	}
+	pid, err := strconv.Atoi(args[0])
+	if err != nil {
+		stream.Reset(syscall.EINVAL)
+		return nil
+	}
+	process := server.lookup(pid)
+	if process == nil {
+		stream.Reset(syscall.ESRCH)
+		return nil
+	}
+
+	return process
+}
+
+//
+// streamOutput --
+//
+// Push all output from the process as it arrives.
+// Each chunk is a JSON-encoded ReadResult, and the
+// stream is closed once all output is done.
+//
+func (server *Server) streamOutput(
+	stream *protocol.Stream,
+	process *Process) {
+
+	defer stream.Close()
+
+	encoder := json.NewEncoder(stream)
+	for {
+		chunk, err := process.read(ReadChunkSize)
+		if err != nil {
+			return
+		}
+		err = encoder.Encode(&chunk)
+		if err != nil {
+			return
+		}
+	}
+}
+
+//
+// streamInput --
+//
+// Write everything from the stream to the process.
+// When the stream is closed, we close standard input.
+//
+func (server *Server) streamInput(
+	stream *protocol.Stream,
+	process *Process) {
+
+	defer stream.Close()
+
+	_, err := io.Copy(process.input, stream)
+	if err == nil {
+		process.closeStdin()
+	}
+}
@@ -14,17 +14,61 @@
 
 package rpc
 
+import (
+	"syscall"
+)
+
 type WaitCommand struct {
 
 	// The relevant pid.
 	Pid int `json:"pid"`
 }
 
+type WaitRusage struct {
+
+	// CPU time (in seconds).
+	UserTime   float64 `json:"user-time"`
+	SystemTime float64 `json:"system-time"`
+
+	// Maximum resident set size (in kilobytes).
+	MaxRss int64 `json:"max-rss"`
+
+	// Page faults.
+	MinorFaults int64 `json:"minor-faults"`
+	MajorFaults int64 `json:"major-faults"`
+
+	// Block I/O operations.
+	InBlock  int64 `json:"in-block"`
+	OutBlock int64 `json:"out-block"`
+
+	// Context switches.
+	VoluntarySwitches   int64 `json:"voluntary-switches"`
+	InvoluntarySwitches int64 `json:"involuntary-switches"`
+}
+
 type WaitResult struct {
 
 	// The exit code.
 	// (If > 0 then this event is an exit event).
+	// If the process was killed by a signal, then
+	// this is 128+signal (as per the shell).
 	Exitcode int `json:"exitcode"`
+
+	// The terminating signal (if any).
+	Signal int `json:"signal"`
+
+	// Was there a core dump?
+	CoreDumped bool `json:"core-dumped"`
+
+	// The wall time (in seconds).
+	WallTime float64 `json:"wall-time"`
+
+	// Resource usage.
+	Rusage WaitRusage `json:"rusage"`
+}
+
+func timevalSeconds(tv syscall.Timeval) float64 {
+	return float64(tv.Sec) + float64(tv.Usec)/1e6
 }
 
 func (server *Server) Wait(
@@ -38,6 +82,24 @@ func (server *Server) Wait(
 	}
 
 	process.wait()
+
+	process.cond.L.Lock()
+	defer process.cond.L.Unlock()
+
 	result.Exitcode = process.exitcode
+	result.Signal = process.signal
+	result.CoreDumped = process.core_dumped
+	result.WallTime = process.exittime.Sub(process.starttime).Seconds()
+	result.Rusage = WaitRusage{
+		UserTime:            timevalSeconds(process.rusage.Utime),
+		SystemTime:          timevalSeconds(process.rusage.Stime),
+		MaxRss:              int64(process.rusage.Maxrss),
+		MinorFaults:         int64(process.rusage.Minflt),
+		MajorFaults:         int64(process.rusage.Majflt),
+		InBlock:             int64(process.rusage.Inblock),
+		OutBlock:            int64(process.rusage.Oublock),
+		VoluntarySwitches:   int64(process.rusage.Nvcsw),
+		InvoluntarySwitches: int64(process.rusage.Nivcsw),
+	}
 	return nil
 }
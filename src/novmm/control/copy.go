@@ -0,0 +1,311 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"archive/tar"
+	"io"
+	"log"
+	"net/rpc"
+	noguest "noguest/rpc"
+	"novmm/utils"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//
+// CopyCommand --
+//
+// The request for a "NOVM CPY\n" stream.
+//
+// Exactly one of Put or Get should be set. For a put,
+// the client sends a tar archive which is unpacked in
+// the guest under the given path. For a get, the given
+// guest paths are sent back to the client as a tar archive.
+//
+type CopyCommand struct {
+	// The guest directory to unpack into.
+	Put string `json:"put,omitempty"`
+
+	// The guest paths to archive.
+	Get []string `json:"get,omitempty"`
+}
+
+func (control *Control) streamCopy(control_file *os.File) {
+
+	decoder := utils.NewDecoder(control_file)
+	encoder := utils.NewEncoder(control_file)
+
+	var command CopyCommand
+	err := decoder.Decode(&command)
+	if err != nil {
+		// Poorly encoded command.
+		encoder.Encode(err.Error())
+		return
+	}
+	if (command.Put == "") == (len(command.Get) == 0) {
+		encoder.Encode(InvalidCopy.Error())
+		return
+	}
+
+	// Grab our own client.
+	// This means that large copies won't
+	// hold up any other guest requests.
+	client, err := control.NewClient()
+	if err != nil {
+		encoder.Encode(err.Error())
+		return
+	}
+	defer client.Close()
+
+	// This indicates we're okay.
+	encoder.Encode(nil)
+
+	if command.Put != "" {
+		// Unpack the archive, and send the result.
+		// Note that the decoder may have buffered some
+		// of the archive already, so we read that first.
+		reader := io.MultiReader(decoder.Buffered(), control_file)
+		err = copyIn(client, command.Put, tar.NewReader(reader))
+		if err != nil {
+			encoder.Encode(err.Error())
+		} else {
+			encoder.Encode(nil)
+		}
+
+	} else {
+		// Send the archive.
+		// There's no way to report an error once
+		// we've started, so we simply truncate it.
+		writer := tar.NewWriter(control_file)
+		for _, get_path := range command.Get {
+			name := strings.TrimPrefix(path.Clean(get_path), "/")
+			if name == "" {
+				name = "."
+			}
+			err = copyOut(client, get_path, name, writer)
+			if err != nil {
+				log.Printf("Copy: %s", err.Error())
+				return
+			}
+		}
+		writer.Close()
+	}
+}
+
+func copyIn(
+	client *rpc.Client,
+	dest string,
+	reader *tar.Reader) error {
+
+	// The links we've created.
+	links := make(map[string]bool)
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// Never escape the destination.
+		// This includes writing through any links
+		// which were created from the archive itself.
+		name := path.Clean("/" + header.Name)
+		if throughLink(links, name) {
+			log.Printf("Copy: skipping %s (beneath a link)", header.Name)
+			continue
+		}
+		put := noguest.PutFileCommand{
+			Path:  path.Join(dest, name),
+			Mode:  uint32(header.Mode) & 07777,
+			Uid:   header.Uid,
+			Gid:   header.Gid,
+			Mtime: header.ModTime.UnixNano(),
+		}
+		var put_result noguest.PutFileResult
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			put.Mode |= syscall.S_IFDIR
+			put.Done = true
+			err = client.Call("Server.PutFile", &put, &put_result)
+			if err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			put.Mode |= syscall.S_IFREG
+			buffer := make([]byte, noguest.FileChunkSize, noguest.FileChunkSize)
+			for !put.Done {
+				n, err := io.ReadFull(reader, buffer)
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					put.Done = true
+				} else if err != nil {
+					return err
+				}
+				put.Data = buffer[:n]
+				err = client.Call("Server.PutFile", &put, &put_result)
+				if err != nil {
+					return err
+				}
+				put.Offset += int64(n)
+			}
+
+		case tar.TypeSymlink:
+			put.Mode |= syscall.S_IFLNK
+			put.Link = header.Linkname
+			put.Done = true
+			err = client.Call("Server.PutFile", &put, &put_result)
+			if err != nil {
+				return err
+			}
+			links[name] = true
+
+		default:
+			log.Printf("Copy: skipping %s (unsupported type)", header.Name)
+		}
+	}
+}
+
+func throughLink(links map[string]bool, name string) bool {
+	for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+		if links[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+func copyOut(
+	client *rpc.Client,
+	get_path string,
+	name string,
+	writer *tar.Writer) error {
+
+	get := noguest.GetFileCommand{
+		Path: get_path,
+		N:    noguest.FileChunkSize,
+	}
+	var get_result noguest.GetFileResult
+	err := client.Call("Server.GetFile", &get, &get_result)
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		Mode:    int64(get_result.Mode & 07777),
+		Uid:     get_result.Uid,
+		Gid:     get_result.Gid,
+		ModTime: time.Unix(0, get_result.Mtime),
+	}
+
+	switch get_result.Mode & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		err = writer.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		// Archive all entries.
+		entries := get_result.Entries
+		sort.Strings(entries)
+		for _, entry := range entries {
+			err = copyOut(
+				client,
+				path.Join(get_path, entry),
+				path.Join(name, entry),
+				writer)
+			if err != nil {
+				return err
+			}
+		}
+
+	case syscall.S_IFREG:
+		header.Typeflag = tar.TypeReg
+		data := get_result.Data
+
+		if get_result.Size == 0 {
+			// Files in /proc and /sys claim to be empty.
+			// We need the size up front, so read it all.
+			for len(get_result.Data) > 0 {
+				get.Offset += int64(len(get_result.Data))
+				err = client.Call("Server.GetFile", &get, &get_result)
+				if err != nil {
+					return err
+				}
+				data = append(data, get_result.Data...)
+			}
+			header.Size = int64(len(data))
+		} else {
+			header.Size = get_result.Size
+		}
+
+		err = writer.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		// Stream the data.
+		// If the file changes size as we read it,
+		// then we truncate it or pad it with zeros.
+		var written int64
+		for written < header.Size {
+			if len(data) == 0 {
+				get.Offset = written
+				err = client.Call("Server.GetFile", &get, &get_result)
+				if err != nil {
+					return err
+				}
+				data = get_result.Data
+				if len(data) == 0 {
+					data = make([]byte, header.Size-written)
+				}
+			}
+			if int64(len(data)) > header.Size-written {
+				data = data[:header.Size-written]
+			}
+			n, err := writer.Write(data)
+			written += int64(n)
+			if err != nil {
+				return err
+			}
+			data = nil
+		}
+
+	case syscall.S_IFLNK:
+		// Archive the link itself (never what it
+		// points to, see noguest's GetFile).
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = get_result.Link
+		err = writer.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+	default:
+		log.Printf("Copy: skipping %s (unsupported type)", get_path)
+	}
+
+	return nil
+}
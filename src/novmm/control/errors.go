@@ -16,7 +16,45 @@ package control
 
 import (
 	"errors"
+	"fmt"
 )
 
 var InvalidControlSocket = errors.New("Invalid control socket?")
 var InternalGuestError = errors.New("Internal guest error?")
+
+// Migration errors.
+var MigrationInvalid = errors.New("Invalid migration stream?")
+
+func MigrationFailed(reason string) error {
+	return errors.New(fmt.Sprintf("Migration failed: %s", reason))
+}
+
+// Snapshot errors.
+var SnapshotInvalid = errors.New("Invalid snapshot file?")
+
+func MissingHostResources(name string) error {
+	return errors.New(fmt.Sprintf("No local state for device %s (host fd)?", name))
+}
+
+// Hot-plug errors.
+var DeviceNotReleased = errors.New("Device not released by guest?")
+
+// Memory access errors.
+var MemoryAccessTooLarge = errors.New("Memory access too large?")
+var MemoryNotMapped = errors.New("Virtual address not mapped?")
+
+// Debugger errors.
+var DebuggerAttached = errors.New("Debugger already attached?")
+var GdbInvalidPacket = errors.New("Invalid gdb packet?")
+
+// Guest errors.
+var GuestNotResponding = errors.New("Guest not responding?")
+
+// Forward errors.
+var ForwardNotFound = errors.New("Forward not found?")
+
+// Descriptor passing errors.
+var FdNotPassed = errors.New("File descriptor not passed?")
+
+// Copy errors.
+var InvalidCopy = errors.New("Copy needs exactly one of put or get?")
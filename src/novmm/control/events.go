@@ -0,0 +1,158 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+//
+// Event types.
+//
+const (
+	EventVcpuDied      = "vcpu-died"
+	EventShutdown      = "shutdown"
+	EventDeviceError   = "device-error"
+	EventPause         = "pause"
+	EventUnpause       = "unpause"
+	EventTrace         = "trace"
+	EventGuestReady    = "guest-ready"
+	EventGuestFailed   = "guest-failed"
+	EventDeviceAdded   = "device-added"
+	EventDeviceRemoved = "device-removed"
+	EventBreakpoint    = "breakpoint"
+	EventPowerButton   = "power-button"
+	EventPowerOff      = "power-off"
+	EventReset         = "reset"
+	EventGuestHealthy  = "guest-healthy"
+	EventGuestStalled  = "guest-stalled"
+	EventMigrated      = "migrated"
+)
+
+//
+// Event --
+//
+// A single asynchronous event. These are pushed
+// to all listeners on the event stream ("NOVM EVT\n").
+// Only the fields relevant for the type are set.
+//
+type Event struct {
+	// The event type (above).
+	Type string `json:"type"`
+
+	// When did this happen?
+	Time time.Time `json:"time"`
+
+	// The vcpu, if any.
+	Vcpu *int `json:"vcpu,omitempty"`
+
+	// The device, if any.
+	Device string `json:"device,omitempty"`
+
+	// Enabled (i.e. for trace toggles).
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// A reason (i.e. for breakpoints).
+	Reason string `json:"reason,omitempty"`
+
+	// An address (i.e. for breakpoints).
+	Address *uint64 `json:"address,omitempty"`
+
+	// An error message.
+	Error string `json:"error,omitempty"`
+}
+
+//
+// Events --
+//
+// Our collection of listeners. Events are never blocked
+// on a listener; if a listener falls too far behind then
+// it will miss events (and we log a warning).
+//
+type Events struct {
+	listeners map[chan Event]bool
+	lock      sync.Mutex
+}
+
+// The number of events buffered per listener.
+var EventBacklog = 64
+
+func NewEvents() *Events {
+	return &Events{listeners: make(map[chan Event]bool)}
+}
+
+func (events *Events) Listen() chan Event {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	listener := make(chan Event, EventBacklog)
+	events.listeners[listener] = true
+	return listener
+}
+
+func (events *Events) Unlisten(listener chan Event) {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	delete(events.listeners, listener)
+}
+
+func (events *Events) Send(event Event) {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for listener, _ := range events.listeners {
+		select {
+		case listener <- event:
+		default:
+			log.Printf("Dropping event %s (listener is full).", event.Type)
+		}
+	}
+}
+
+func (events *Events) VcpuEvent(event_type string, id int, err error) {
+	event := Event{Type: event_type, Vcpu: &id}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	events.Send(event)
+}
+
+func (events *Events) VcpuExit(id int, err error) {
+	if err != nil {
+		events.VcpuEvent(EventVcpuDied, id, err)
+	} else {
+		// The guest has shutdown.
+		events.VcpuEvent(EventShutdown, id, nil)
+	}
+}
+
+func (events *Events) PowerOff() {
+	events.Send(Event{Type: EventPowerOff})
+}
+
+func (events *Events) Reset() {
+	events.Send(Event{Type: EventReset})
+}
+
+func (events *Events) Migrated() {
+	events.Send(Event{Type: EventMigrated})
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+//
+// The most descriptors we will accept in one message.
+//
+const FdConnMaxFds = 64
+
+//
+// FdConn --
+//
+// A control connection which accepts file descriptors.
+// Any descriptors passed alongside the data (SCM_RIGHTS)
+// are kept in the order received, and may be claimed by
+// index (see Rpc.AddDevice). Anything left unclaimed is
+// closed along with the connection.
+//
+type FdConn struct {
+	*os.File
+
+	// Received descriptors (-1 once claimed).
+	fds []int
+
+	// Protects fds.
+	lock sync.Mutex
+}
+
+func NewFdConn(file *os.File) *FdConn {
+	return &FdConn{File: file}
+}
+
+func (conn *FdConn) Read(data []byte) (int, error) {
+
+	oob := make([]byte, syscall.CmsgSpace(FdConnMaxFds*4))
+	for {
+		n, oobn, _, _, err := syscall.Recvmsg(
+			int(conn.File.Fd()),
+			data,
+			oob,
+			syscall.MSG_CMSG_CLOEXEC)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		if oobn > 0 {
+			conn.save(oob[:oobn])
+		}
+		if n == 0 && len(data) > 0 {
+			return 0, io.EOF
+		}
+		return n, nil
+	}
+}
+
+func (conn *FdConn) save(oob []byte) {
+
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	for _, message := range messages {
+		fds, err := syscall.ParseUnixRights(&message)
+		if err != nil {
+			continue
+		}
+		conn.fds = append(conn.fds, fds...)
+	}
+}
+
+//
+// Claim --
+//
+// Take ownership of the given descriptor.
+// The index is the position of the descriptor
+// amongst all those passed on this connection.
+//
+func (conn *FdConn) Claim(index int) (int, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if index < 0 || index >= len(conn.fds) || conn.fds[index] < 0 {
+		return -1, FdNotPassed
+	}
+	fd := conn.fds[index]
+	conn.fds[index] = -1
+	return fd, nil
+}
+
+func (conn *FdConn) Close() error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	for i, fd := range conn.fds {
+		if fd >= 0 {
+			syscall.Close(fd)
+			conn.fds[i] = -1
+		}
+	}
+	return conn.File.Close()
+}
@@ -0,0 +1,137 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"log"
+	"net"
+	"noguest/protocol"
+	"sync"
+)
+
+//
+// Forward --
+//
+// A host listener which forwards connections to
+// a guest-local address. Each connection is dialed
+// by noguest and the data is passed over its own
+// stream, so no guest networking is required.
+//
+type Forward struct {
+	// The host listener.
+	listener net.Listener
+
+	// The guest address.
+	network string
+	address string
+
+	// Opens guest streams (see Control.OpenStream).
+	open func(name string) (*protocol.Stream, error)
+}
+
+func NewForward(
+	network string,
+	address string,
+	guest_network string,
+	guest_address string,
+	open func(name string) (*protocol.Stream, error)) (*Forward, error) {
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	forward := &Forward{
+		listener: listener,
+		network:  guest_network,
+		address:  guest_address,
+		open:     open,
+	}
+	go forward.serve()
+	return forward, nil
+}
+
+func (forward *Forward) Addr() net.Addr {
+	return forward.listener.Addr()
+}
+
+func (forward *Forward) Close() error {
+	return forward.listener.Close()
+}
+
+func (forward *Forward) serve() {
+	for {
+		conn, err := forward.listener.Accept()
+		if err != nil {
+			// Closed.
+			return
+		}
+		go forward.handle(conn)
+	}
+}
+
+func (forward *Forward) handle(conn net.Conn) {
+
+	// Connect in the guest.
+	stream, err := forward.open(protocol.StreamName(
+		protocol.StreamDial,
+		forward.network,
+		forward.address))
+	if err != nil {
+		log.Printf("Forward: %s", err.Error())
+		conn.Close()
+		return
+	}
+
+	stream.Splice(conn)
+}
+
+//
+// Forwards --
+//
+// The set of active forwards.
+//
+type Forwards struct {
+	forwards map[int]*Forward
+	next_id  int
+	lock     sync.Mutex
+}
+
+func NewForwards() *Forwards {
+	return &Forwards{
+		forwards: make(map[int]*Forward),
+	}
+}
+
+func (forwards *Forwards) Add(forward *Forward) int {
+	forwards.lock.Lock()
+	defer forwards.lock.Unlock()
+
+	forwards.next_id += 1
+	forwards.forwards[forwards.next_id] = forward
+	return forwards.next_id
+}
+
+func (forwards *Forwards) Remove(id int) error {
+	forwards.lock.Lock()
+	forward, ok := forwards.forwards[id]
+	delete(forwards.forwards, id)
+	forwards.lock.Unlock()
+
+	if !ok {
+		return ForwardNotFound
+	}
+	return forward.Close()
+}
@@ -0,0 +1,125 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"log"
+	"net/rpc"
+	noguest "noguest/rpc"
+	"time"
+)
+
+//
+// How long we wait for the guest to freeze
+// or thaw its filesystems. The machine is running
+// during this time, so we don't hold it up forever.
+//
+var FreezeTimeout = 30 * time.Second
+
+//
+// GuestFreezer --
+//
+// Freezes filesystems using the in-guest agent.
+//
+type GuestFreezer struct {
+	// Our guest client (see Control.Ready).
+	guest func() (*rpc.Client, error)
+
+	// The mount points (empty for all).
+	paths []string
+
+	// The mount points actually frozen.
+	frozen []string
+}
+
+func NewGuestFreezer(
+	guest func() (*rpc.Client, error),
+	paths []string) *GuestFreezer {
+
+	return &GuestFreezer{
+		guest: guest,
+		paths: paths,
+	}
+}
+
+func (freezer *GuestFreezer) call(
+	method string,
+	args interface{},
+	reply interface{}) chan error {
+
+	done := make(chan error, 1)
+	go func() {
+		client, err := freezer.guest()
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- client.Call(method, args, reply)
+	}()
+	return done
+}
+
+func (freezer *GuestFreezer) Freeze() error {
+
+	freeze := noguest.FreezeCommand{Paths: freezer.paths}
+	var freeze_result noguest.FreezeResult
+	done := freezer.call("Server.Freeze", &freeze, &freeze_result)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-time.After(FreezeTimeout):
+		// The guest may still get there eventually,
+		// so we thaw whatever it froze once it does.
+		go func() {
+			if <-done == nil {
+				late := NewGuestFreezer(freezer.guest, nil)
+				late.frozen = freeze_result.Frozen
+				late.Thaw()
+			}
+		}()
+		return GuestNotResponding
+	}
+
+	freezer.frozen = freeze_result.Frozen
+	return nil
+}
+
+func (freezer *GuestFreezer) Thaw() error {
+
+	if len(freezer.frozen) == 0 {
+		// Nothing to do (and we don't want to
+		// thaw anything frozen by someone else).
+		return nil
+	}
+
+	thaw := noguest.ThawCommand{Paths: freezer.frozen}
+	var thaw_result noguest.ThawResult
+	done := freezer.call("Server.Thaw", &thaw, &thaw_result)
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(FreezeTimeout):
+		err = GuestNotResponding
+	}
+	if err != nil {
+		// This is bad, the guest may now be stuck.
+		log.Printf("Unable to thaw guest: %s", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,922 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"novmm/machine"
+	"novmm/platform"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//
+// Debugger --
+//
+// A GDB remote serial protocol stub ("NOVM GDB\n").
+//
+// This is an all-stop debugger: when any vcpu stops (on
+// a breakpoint, after a single step or when interrupted)
+// all other vcpus are paused as well. Each vcpu appears as
+// a thread to gdb (with thread id = vcpu id + 1).
+//
+// Software breakpoints are implemented by writing int3
+// into guest memory, and asking KVM to exit on these
+// rather than deliver them to the guest.
+//
+
+type debugStop struct {
+	// The vcpu that stopped.
+	vcpu int
+
+	// The reason.
+	exit *platform.ExitDebug
+}
+
+type Debugger struct {
+	// Our underlying vm & model.
+	vm    *platform.Vm
+	model *machine.Model
+
+	// Our event stream (for breakpoints without gdb).
+	events *Events
+
+	// Our hardware breakpoints & watchpoints.
+	// These are shared by gdb and Rpc.Breakpoint.
+	hw_breakpoints []platform.Vaddr
+	hw_watchpoints []platform.Watchpoint
+
+	// Is a debugger attached?
+	attached bool
+
+	// Pauses held on each vcpu.
+	held []int
+
+	// Lock protecting the above.
+	lock sync.Mutex
+
+	// Stops (from vcpu threads).
+	stops chan debugStop
+
+	// Our software breakpoints.
+	// These map to the original byte.
+	breakpoints map[uint64]byte
+
+	// The current vcpu (for registers, memory, etc.).
+	current int
+}
+
+func NewDebugger(
+	vm *platform.Vm,
+	model *machine.Model,
+	events *Events) *Debugger {
+
+	return &Debugger{
+		vm:     vm,
+		model:  model,
+		events: events,
+		held:   make([]int, len(vm.Vcpus()), len(vm.Vcpus())),
+		stops:  make(chan debugStop, len(vm.Vcpus())),
+	}
+}
+
+func (debugger *Debugger) Exit(
+	vcpu *platform.Vcpu,
+	exit *platform.ExitDebug) error {
+
+	if exit.Reason() == platform.DebugHardwareBreakpoint {
+		// Set the resume flag (RF) so that we
+		// don't hit this breakpoint again immediately.
+		rflags, err := vcpu.GetRegister(platform.RFLAGS)
+		if err != nil {
+			return err
+		}
+		err = vcpu.SetRegister(platform.RFLAGS, rflags|(1<<16))
+		if err != nil {
+			return err
+		}
+	}
+
+	debugger.lock.Lock()
+	defer debugger.lock.Unlock()
+
+	if !debugger.attached {
+		switch exit.Reason() {
+		case platform.DebugHardwareBreakpoint, platform.DebugWatchpoint:
+			// Pause here, as if this was done via Rpc.Vcpu.
+			// The vcpu can be resumed the same way.
+			err := vcpu.PauseSelf(true)
+			if err != nil && err != platform.AlreadyPaused {
+				return err
+			}
+			id := int(vcpu.Id)
+			address := uint64(exit.Address())
+			debugger.events.Send(Event{
+				Type:    EventBreakpoint,
+				Vcpu:    &id,
+				Reason:  exit.Reason().String(),
+				Address: &address})
+		}
+
+		// Otherwise, nothing to do (i.e. tracing).
+		return nil
+	}
+
+	// Stop this vcpu here.
+	// We will be resumed by the debugger.
+	vcpu.PauseSelf(false)
+	debugger.held[vcpu.Id] += 1
+
+	// Let the debugger know.
+	// Each vcpu is held after a stop, so there
+	// will always be room in the channel.
+	select {
+	case debugger.stops <- debugStop{vcpu: int(vcpu.Id), exit: exit}:
+	default:
+	}
+
+	return nil
+}
+
+func (debugger *Debugger) stopAll() error {
+
+	// NOTE: We don't hold the lock while pausing,
+	// as the vcpu may be waiting on it in Exit().
+	for id, vcpu := range debugger.vm.Vcpus() {
+		err := vcpu.Pause(false)
+		if err != nil {
+			return err
+		}
+		debugger.lock.Lock()
+		debugger.held[id] += 1
+		debugger.lock.Unlock()
+	}
+
+	return nil
+}
+
+func (debugger *Debugger) resume(only int) {
+
+	debugger.lock.Lock()
+	defer debugger.lock.Unlock()
+
+	// Drop any outstanding stops.
+	// If these were breakpoints, the vcpu
+	// will simply hit them again on resume.
+	for {
+		select {
+		case <-debugger.stops:
+			continue
+		default:
+		}
+		break
+	}
+
+	for id, vcpu := range debugger.vm.Vcpus() {
+		if only >= 0 && id != only {
+			continue
+		}
+		for ; debugger.held[id] > 0; debugger.held[id] -= 1 {
+			vcpu.Unpause(false)
+		}
+	}
+}
+
+func (debugger *Debugger) attach() error {
+
+	debugger.lock.Lock()
+	if debugger.attached {
+		debugger.lock.Unlock()
+		return DebuggerAttached
+	}
+	debugger.attached = true
+	debugger.breakpoints = make(map[uint64]byte)
+	debugger.current = 0
+	debugger.lock.Unlock()
+
+	// Stop everything.
+	err := debugger.stopAll()
+	if err != nil {
+		debugger.detach()
+		return err
+	}
+
+	// Intercept all software breakpoints.
+	for _, vcpu := range debugger.vm.Vcpus() {
+		err := vcpu.SetSoftwareBreakpoints(true)
+		if err != nil {
+			debugger.detach()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (debugger *Debugger) detach() {
+
+	// Restore the original memory.
+	for addr, _ := range debugger.breakpoints {
+		debugger.removeBreakpoint(addr)
+	}
+
+	for _, vcpu := range debugger.vm.Vcpus() {
+		vcpu.SetStepping(false)
+		vcpu.SetSoftwareBreakpoints(false)
+	}
+
+	debugger.lock.Lock()
+	debugger.attached = false
+	debugger.lock.Unlock()
+
+	// Let everything go.
+	debugger.resume(-1)
+}
+
+func (debugger *Debugger) SetHardware(
+	breakpoints []platform.Vaddr,
+	watchpoints []platform.Watchpoint) error {
+
+	// Will this fit?
+	// We check before touching any vcpu, so that
+	// we never leave things partially applied.
+	err := platform.CheckDebugRegisters(breakpoints, watchpoints)
+	if err != nil {
+		return err
+	}
+
+	// Ensure no vcpus are running.
+	err = debugger.vm.Pause(false)
+	if err != nil {
+		return err
+	}
+	defer debugger.vm.Unpause(false)
+
+	for _, vcpu := range debugger.vm.Vcpus() {
+		err = vcpu.SetDebugRegisters(breakpoints, watchpoints)
+		if err != nil {
+			// Try to restore our original state.
+			for _, vcpu := range debugger.vm.Vcpus() {
+				vcpu.SetDebugRegisters(
+					debugger.hw_breakpoints,
+					debugger.hw_watchpoints)
+			}
+			return err
+		}
+	}
+
+	debugger.hw_breakpoints = breakpoints
+	debugger.hw_watchpoints = watchpoints
+	return nil
+}
+
+func (debugger *Debugger) hardwareBreakpoint(
+	kind byte,
+	addr uint64,
+	length uint64,
+	insert bool) error {
+
+	breakpoints := make([]platform.Vaddr, 0, len(debugger.hw_breakpoints)+1)
+	watchpoints := make([]platform.Watchpoint, 0, len(debugger.hw_watchpoints)+1)
+
+	if kind == '1' {
+		// Instruction breakpoint.
+		for _, other := range debugger.hw_breakpoints {
+			if other != platform.Vaddr(addr) {
+				breakpoints = append(breakpoints, other)
+			}
+		}
+		if insert {
+			breakpoints = append(breakpoints, platform.Vaddr(addr))
+		}
+		watchpoints = append(watchpoints, debugger.hw_watchpoints...)
+
+	} else {
+		// Watchpoint (2 = write, 3 = read, 4 = access).
+		// We can't trap only reads, so these are the
+		// same as access watchpoints (and reported so).
+		watchpoint := platform.Watchpoint{
+			Address: platform.Vaddr(addr),
+			Length:  uint(length),
+			Access:  kind != '2',
+		}
+		for _, other := range debugger.hw_watchpoints {
+			if other != watchpoint {
+				watchpoints = append(watchpoints, other)
+			}
+		}
+		if insert {
+			watchpoints = append(watchpoints, watchpoint)
+		}
+		breakpoints = append(breakpoints, debugger.hw_breakpoints...)
+	}
+
+	return debugger.SetHardware(breakpoints, watchpoints)
+}
+
+func (debugger *Debugger) insertBreakpoint(addr uint64) error {
+
+	if _, ok := debugger.breakpoints[addr]; ok {
+		// Already inserted.
+		return nil
+	}
+
+	orig, err := ReadMemory(
+		debugger.vm,
+		debugger.model,
+		addr,
+		1,
+		true,
+		debugger.current)
+	if err != nil {
+		return err
+	}
+
+	// Write our int3.
+	err = WriteMemory(
+		debugger.vm,
+		debugger.model,
+		addr,
+		[]byte{0xcc},
+		true,
+		debugger.current)
+	if err != nil {
+		return err
+	}
+
+	debugger.breakpoints[addr] = orig[0]
+	return nil
+}
+
+func (debugger *Debugger) removeBreakpoint(addr uint64) error {
+
+	orig, ok := debugger.breakpoints[addr]
+	if !ok {
+		// Not inserted.
+		return nil
+	}
+	delete(debugger.breakpoints, addr)
+
+	return WriteMemory(
+		debugger.vm,
+		debugger.model,
+		addr,
+		[]byte{orig},
+		true,
+		debugger.current)
+}
+
+//
+// The gdb wire protocol.
+//
+// Packets are $data#cs, and are acknowledged with
+// a '+'. A bare 0x03 byte is an interrupt request.
+// We acknowledge incoming packets, but we never
+// retransmit, so we ignore acknowledgements.
+//
+
+const gdbInterrupt = "\x03"
+
+type gdbConn struct {
+	conn io.ReadWriter
+
+	// Incoming packets (from read()).
+	packets chan string
+
+	// Lock protecting writes.
+	lock sync.Mutex
+}
+
+func gdbChecksum(data string) uint8 {
+	sum := uint8(0)
+	for i := 0; i < len(data); i += 1 {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (gdb *gdbConn) write(data string) error {
+	gdb.lock.Lock()
+	defer gdb.lock.Unlock()
+
+	_, err := io.WriteString(gdb.conn, data)
+	return err
+}
+
+func (gdb *gdbConn) send(data string) error {
+	return gdb.write(fmt.Sprintf("$%s#%02x", data, gdbChecksum(data)))
+}
+
+func (gdb *gdbConn) read() {
+
+	defer close(gdb.packets)
+	reader := bufio.NewReader(gdb.conn)
+
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch c {
+		case 0x03:
+			gdb.packets <- gdbInterrupt
+
+		case '$':
+			data, err := reader.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+
+			sum := make([]byte, 2, 2)
+			_, err = io.ReadFull(reader, sum)
+			if err != nil {
+				return
+			}
+			expected, err := strconv.ParseUint(string(sum), 16, 8)
+			if err != nil || uint8(expected) != gdbChecksum(data) {
+				gdb.write("-")
+				continue
+			}
+
+			gdb.write("+")
+			gdb.packets <- data
+		}
+	}
+}
+
+//
+// Registers (in the order of the amd64 'g' packet).
+//
+var gdbRegisters = []platform.Register{
+	platform.RAX,
+	platform.RBX,
+	platform.RCX,
+	platform.RDX,
+	platform.RSI,
+	platform.RDI,
+	platform.RBP,
+	platform.RSP,
+	platform.R8,
+	platform.R9,
+	platform.R10,
+	platform.R11,
+	platform.R12,
+	platform.R13,
+	platform.R14,
+	platform.R15,
+	platform.RIP,
+}
+
+func gdbEncode(value uint64, size int) string {
+	buf := make([]byte, 8, 8)
+	binary.LittleEndian.PutUint64(buf, value)
+	return hex.EncodeToString(buf[:size])
+}
+
+func gdbDecode(data string, size int) (uint64, string, error) {
+	if len(data) < 2*size {
+		return 0, data, GdbInvalidPacket
+	}
+	buf := make([]byte, 8, 8)
+	_, err := hex.Decode(buf, []byte(data[:2*size]))
+	if err != nil {
+		return 0, data, err
+	}
+	return binary.LittleEndian.Uint64(buf), data[2*size:], nil
+}
+
+func (debugger *Debugger) vcpu() *platform.Vcpu {
+	return debugger.vm.Vcpus()[debugger.current]
+}
+
+func (debugger *Debugger) readRegisters() (string, error) {
+
+	vcpu := debugger.vcpu()
+	output := ""
+
+	for _, reg := range gdbRegisters {
+		value, err := vcpu.GetRegister(reg)
+		if err != nil {
+			return "", err
+		}
+		output += gdbEncode(uint64(value), 8)
+	}
+
+	rflags, err := vcpu.GetRegister(platform.RFLAGS)
+	if err != nil {
+		return "", err
+	}
+	output += gdbEncode(uint64(rflags), 4)
+
+	for _, seg := range []platform.Segment{
+		platform.CS,
+		platform.SS,
+		platform.DS,
+		platform.ES,
+		platform.FS,
+		platform.GS} {
+
+		value, err := vcpu.GetSegment(seg)
+		if err != nil {
+			return "", err
+		}
+		output += gdbEncode(uint64(value.Selector), 4)
+	}
+
+	return output, nil
+}
+
+func (debugger *Debugger) writeRegisters(data string) error {
+
+	var regs platform.Registers
+	values := make([]platform.RegisterValue, len(gdbRegisters)+1)
+
+	for i, _ := range gdbRegisters {
+		value, rest, err := gdbDecode(data, 8)
+		if err != nil {
+			return err
+		}
+		values[i] = platform.RegisterValue(value)
+		data = rest
+	}
+	rflags, _, err := gdbDecode(data, 4)
+	if err != nil {
+		return err
+	}
+	values[len(gdbRegisters)] = platform.RegisterValue(rflags)
+
+	// NOTE: We don't allow segments to be changed.
+	// Only the selector is available, which would
+	// leave the hidden part of the segment stale.
+	regs.RAX = &values[0]
+	regs.RBX = &values[1]
+	regs.RCX = &values[2]
+	regs.RDX = &values[3]
+	regs.RSI = &values[4]
+	regs.RDI = &values[5]
+	regs.RBP = &values[6]
+	regs.RSP = &values[7]
+	regs.R8 = &values[8]
+	regs.R9 = &values[9]
+	regs.R10 = &values[10]
+	regs.R11 = &values[11]
+	regs.R12 = &values[12]
+	regs.R13 = &values[13]
+	regs.R14 = &values[14]
+	regs.R15 = &values[15]
+	regs.RIP = &values[16]
+	regs.RFLAGS = &values[17]
+
+	return debugger.vcpu().SetRegisters(regs)
+}
+
+func (debugger *Debugger) setPc(data string) error {
+
+	if data == "" {
+		// Continue where we are.
+		return nil
+	}
+
+	addr, err := strconv.ParseUint(data, 16, 64)
+	if err != nil {
+		return err
+	}
+
+	rip := platform.RegisterValue(addr)
+	return debugger.vcpu().SetRegisters(platform.Registers{RIP: &rip})
+}
+
+func gdbAddress(data string) (uint64, uint64, string, error) {
+
+	// Parse an "addr,length[:rest]" pair.
+	var rest string
+	if colon := strings.Index(data, ":"); colon >= 0 {
+		rest = data[colon+1:]
+		data = data[:colon]
+	}
+	parts := strings.SplitN(data, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, rest, GdbInvalidPacket
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 64)
+	if err != nil {
+		return 0, 0, rest, err
+	}
+	length, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return 0, 0, rest, err
+	}
+
+	return addr, length, rest, nil
+}
+
+func (debugger *Debugger) readMemory(data string) (string, error) {
+
+	addr, length, _, err := gdbAddress(data)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ReadMemory(
+		debugger.vm,
+		debugger.model,
+		addr,
+		length,
+		true,
+		debugger.current)
+	if err != nil {
+		return "", err
+	}
+
+	// Hide our breakpoints.
+	for bp_addr, orig := range debugger.breakpoints {
+		if bp_addr >= addr && bp_addr < addr+length {
+			output[bp_addr-addr] = orig
+		}
+	}
+
+	return hex.EncodeToString(output), nil
+}
+
+func (debugger *Debugger) writeMemory(data string) error {
+
+	addr, length, rest, err := gdbAddress(data)
+	if err != nil {
+		return err
+	}
+	input, err := hex.DecodeString(rest)
+	if err != nil {
+		return err
+	}
+	if uint64(len(input)) != length {
+		return GdbInvalidPacket
+	}
+
+	return WriteMemory(
+		debugger.vm,
+		debugger.model,
+		addr,
+		input,
+		true,
+		debugger.current)
+}
+
+func (debugger *Debugger) breakpoint(data string, insert bool) (string, error) {
+
+	// Types 0 (software), 1 (hardware) and
+	// 2-4 (write, read and access watchpoints).
+	if len(data) < 2 || data[0] < '0' || data[0] > '4' || data[1] != ',' {
+		return "", nil
+	}
+	addr, length, _, err := gdbAddress(data[2:])
+	if err != nil {
+		return "", err
+	}
+
+	if data[0] != '0' {
+		err = debugger.hardwareBreakpoint(data[0], addr, length, insert)
+	} else if insert {
+		err = debugger.insertBreakpoint(addr)
+	} else {
+		err = debugger.removeBreakpoint(addr)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "OK", nil
+}
+
+func (debugger *Debugger) thread(data string) (int, bool) {
+
+	// Thread ids are signed hex.
+	// (0 means any thread, -1 means all threads).
+	id, err := strconv.ParseInt(data, 16, 64)
+	if err != nil || id > int64(len(debugger.vm.Vcpus())) {
+		return -1, false
+	}
+
+	return int(id) - 1, true
+}
+
+func (debugger *Debugger) query(data string) string {
+
+	switch {
+	case strings.HasPrefix(data, "Supported"):
+		return "PacketSize=4000"
+
+	case data == "Attached":
+		// We never create the process.
+		return "1"
+
+	case data == "C":
+		return fmt.Sprintf("QC%x", debugger.current+1)
+
+	case data == "fThreadInfo":
+		threads := make([]string, 0, len(debugger.vm.Vcpus()))
+		for id, _ := range debugger.vm.Vcpus() {
+			threads = append(threads, fmt.Sprintf("%x", id+1))
+		}
+		return "m" + strings.Join(threads, ",")
+
+	case data == "sThreadInfo":
+		return "l"
+	}
+
+	return ""
+}
+
+func (debugger *Debugger) stopReply(signal int) string {
+	return fmt.Sprintf("T%02xthread:%x;", signal, debugger.current+1)
+}
+
+func (debugger *Debugger) watchReply(exit *platform.ExitDebug) string {
+
+	kind := "watch"
+	for _, watchpoint := range debugger.hw_watchpoints {
+		if watchpoint.Address == exit.Address() && watchpoint.Access {
+			kind = "awatch"
+		}
+	}
+
+	return fmt.Sprintf(
+		"T05%s:%x;thread:%x;",
+		kind,
+		uint64(exit.Address()),
+		debugger.current+1)
+}
+
+func (debugger *Debugger) handle(data string) (string, bool, error) {
+
+	switch data[0] {
+	case '?':
+		return debugger.stopReply(5), false, nil
+
+	case 'g':
+		output, err := debugger.readRegisters()
+		return output, false, err
+
+	case 'G':
+		return "OK", false, debugger.writeRegisters(data[1:])
+
+	case 'm':
+		output, err := debugger.readMemory(data[1:])
+		return output, false, err
+
+	case 'M':
+		return "OK", false, debugger.writeMemory(data[1:])
+
+	case 'Z', 'z':
+		output, err := debugger.breakpoint(data[1:], data[0] == 'Z')
+		return output, false, err
+
+	case 'c':
+		err := debugger.setPc(data[1:])
+		if err != nil {
+			return "", false, err
+		}
+		for _, vcpu := range debugger.vm.Vcpus() {
+			err = vcpu.SetStepping(false)
+			if err != nil {
+				return "", false, err
+			}
+		}
+		debugger.resume(-1)
+		return "", true, nil
+
+	case 's':
+		err := debugger.setPc(data[1:])
+		if err != nil {
+			return "", false, err
+		}
+		err = debugger.vcpu().SetStepping(true)
+		if err != nil {
+			return "", false, err
+		}
+		debugger.resume(debugger.current)
+		return "", true, nil
+
+	case 'H':
+		if len(data) < 2 {
+			return "", false, GdbInvalidPacket
+		}
+		id, ok := debugger.thread(data[2:])
+		if !ok {
+			return "", false, GdbInvalidPacket
+		}
+		if id >= 0 {
+			debugger.current = id
+		}
+		return "OK", false, nil
+
+	case 'T':
+		id, ok := debugger.thread(data[1:])
+		if !ok || id < 0 {
+			return "", false, GdbInvalidPacket
+		}
+		return "OK", false, nil
+
+	case 'q':
+		return debugger.query(data[1:]), false, nil
+	}
+
+	// Unsupported.
+	return "", false, nil
+}
+
+func (debugger *Debugger) wait(gdb *gdbConn) (string, bool) {
+
+	for {
+		select {
+		case stop := <-debugger.stops:
+			debugger.stopAll()
+			debugger.current = stop.vcpu
+			if stop.exit.Reason() == platform.DebugWatchpoint {
+				return debugger.watchReply(stop.exit), true
+			}
+			return debugger.stopReply(5), true
+
+		case data, ok := <-gdb.packets:
+			if !ok {
+				// Disconnected.
+				debugger.stopAll()
+				return "", false
+			}
+			if data == gdbInterrupt {
+				debugger.stopAll()
+				return debugger.stopReply(2), true
+			}
+
+			// Nothing else is valid while running.
+		}
+	}
+}
+
+func (debugger *Debugger) Serve(conn io.ReadWriter) error {
+
+	err := debugger.attach()
+	if err != nil {
+		return err
+	}
+	defer debugger.detach()
+
+	gdb := &gdbConn{
+		conn:    conn,
+		packets: make(chan string),
+	}
+	go gdb.read()
+
+	for data := range gdb.packets {
+
+		if data == gdbInterrupt || data == "" {
+			// Already stopped.
+			continue
+		}
+
+		switch data[0] {
+		case 'D':
+			// Detach.
+			return gdb.send("OK")
+		case 'k':
+			// Kill. We simply detach.
+			return nil
+		}
+
+		output, running, err := debugger.handle(data)
+		if err != nil {
+			output = "E01"
+		} else if running {
+			var ok bool
+			output, ok = debugger.wait(gdb)
+			if !ok {
+				return nil
+			}
+		}
+
+		err = gdb.send(output)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
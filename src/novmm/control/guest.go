@@ -15,33 +15,155 @@
 package control
 
 import (
+	"io"
 	"net/rpc"
 	"net/rpc/jsonrpc"
 	"noguest/protocol"
+	"novmm/machine"
+	"sync"
 )
 
-func (control *Control) init() {
+// The most we read from the proxy at once.
+const GuestPumpSize = 4096
+
+//
+// guestConn --
+//
+// Our channel to a single run of the in-guest agent.
+//
+// All data from the proxy is read by a single goroutine
+// (see Control.pump) and handed to the current connection.
+// When the machine is reset, the connection is closed so
+// that nothing left over (i.e. the old session) is able to
+// read or write, and the agent starts over on a new one.
+//
+type guestConn struct {
+	// Data from the proxy.
+	reader *io.PipeReader
+	writer *io.PipeWriter
+
+	// The underlying proxy (for writes).
+	proxy machine.Proxy
+
+	// Set once closed.
+	closed bool
+	lock   sync.Mutex
+}
+
+func newGuestConn(proxy machine.Proxy) *guestConn {
+	reader, writer := io.Pipe()
+	return &guestConn{
+		reader: reader,
+		writer: writer,
+		proxy:  proxy,
+	}
+}
+
+func (conn *guestConn) Read(data []byte) (int, error) {
+	return conn.reader.Read(data)
+}
+
+func (conn *guestConn) Write(data []byte) (int, error) {
+	conn.lock.Lock()
+	closed := conn.closed
+	conn.lock.Unlock()
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	return conn.proxy.Write(data)
+}
+
+func (conn *guestConn) Close() error {
+	conn.lock.Lock()
+	conn.closed = true
+	conn.lock.Unlock()
+
+	// This will unblock any reader, and any pending
+	// data from the proxy will simply be discarded.
+	return conn.reader.Close()
+}
+
+//
+// guestAgent --
+//
+// The state for a single run of the in-guest agent.
+//
+type guestAgent struct {
+	// Our channel.
+	conn *guestConn
+
+	// Our bound client.
+	// NOTE: We have this setup as a lazy function
+	// because the guest may take some small amount of
+	// time before it's actually ready to process RPC
+	// requests. We don't want this to interfere with
+	// our ability to process our host-side requests.
+	client_res  chan error
+	client_err  error
+	client_once sync.Once
+	client      *rpc.Client
+
+	// Our session with the in-guest agent.
+	// Everything after the initial handshake is
+	// multiplexed over the proxy as streams (the
+	// client above is simply the first of these).
+	session *protocol.Session
+}
+
+func newGuestAgent(proxy machine.Proxy) *guestAgent {
+	return &guestAgent{
+		conn:       newGuestConn(proxy),
+		client_res: make(chan error, 1),
+	}
+}
+
+func (control *Control) pump() {
+
+	buffer := make([]byte, GuestPumpSize, GuestPumpSize)
+	for {
+		n, err := control.proxy.Read(buffer)
+
+		// Whoever is current gets the data.
+		// (Write will fail if it was closed.)
+		agent := control.current()
+		if n > 0 {
+			agent.conn.writer.Write(buffer[:n])
+		}
+		if err != nil {
+			agent.conn.writer.CloseWithError(err)
+			return
+		}
+	}
+}
+
+func (control *Control) current() *guestAgent {
+	control.agent_lock.Lock()
+	defer control.agent_lock.Unlock()
+	return control.agent
+}
+
+func (control *Control) init(agent *guestAgent) {
 
 	buffer := make([]byte, 1, 1)
 
 	// Read our control byte back.
-	n, err := control.proxy.Read(buffer)
+	n, err := agent.conn.Read(buffer)
 	if n == 1 && err == nil {
 		switch buffer[0] {
 		case protocol.NoGuestStatusOkay:
 			break
 		case protocol.NoGuestStatusFailed:
 			// Something went horribly wrong.
-			control.client_res <- InternalGuestError
+			control.ready(agent, InternalGuestError)
 			return
 		default:
 			// This isn't good, who knows what happened?
-			control.client_res <- protocol.UnknownStatus
+			control.ready(agent, protocol.UnknownStatus)
 			return
 		}
 	} else if err != nil {
 		// An actual error.
-		control.client_res <- err
+		control.ready(agent, err)
 		return
 	}
 
@@ -58,24 +180,110 @@ func (control *Control) init() {
 	} else {
 		buffer[0] = protocol.NoGuestCommandFakeInit
 	}
-	n, err = control.proxy.Write(buffer)
+	n, err = agent.conn.Write(buffer)
 	if n != 1 {
 		// Can't send anything?
-		control.client_res <- InternalGuestError
+		control.ready(agent, InternalGuestError)
 		return
 	}
 
 	// Looks like we're good.
-	control.client_res <- nil
+	control.ready(agent, nil)
 }
 
-func (control *Control) barrier() {
-	control.client_err = <-control.client_res
-	control.client_codec = jsonrpc.NewClientCodec(control.proxy)
-	control.client = rpc.NewClientWithCodec(control.client_codec)
+func (control *Control) ready(agent *guestAgent, err error) {
+
+	// Let everyone know.
+	if err == nil {
+		control.events.Send(Event{Type: EventGuestReady})
+	} else {
+		control.events.Send(Event{
+			Type:  EventGuestFailed,
+			Error: err.Error()})
+	}
+
+	agent.client_res <- err
+}
+
+func (agent *guestAgent) barrier() {
+	agent.client_err = <-agent.client_res
+	if agent.client_err != nil {
+		return
+	}
+
+	agent.session = protocol.NewSession(agent.conn, true)
+	agent.client, agent.client_err = agent.newClient()
+}
+
+func (agent *guestAgent) newClient() (*rpc.Client, error) {
+	stream, err := agent.session.Open(protocol.StreamName(protocol.StreamRpc))
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClientWithCodec(jsonrpc.NewClientCodec(stream)), nil
+}
+
+func (agent *guestAgent) ready() error {
+	agent.client_once.Do(agent.barrier)
+	return agent.client_err
 }
 
 func (control *Control) Ready() (*rpc.Client, error) {
-	control.client_once.Do(control.barrier)
-	return control.client, control.client_err
+	agent := control.current()
+	err := agent.ready()
+	return agent.client, err
+}
+
+//
+// NewClient --
+//
+// Create a new client with its own stream.
+//
+// This should be used for bulk transfers, so that
+// they don't hold up other requests. The caller is
+// responsible for closing the client.
+//
+func (control *Control) NewClient() (*rpc.Client, error) {
+	agent := control.current()
+	err := agent.ready()
+	if err != nil {
+		return nil, err
+	}
+	return agent.newClient()
+}
+
+//
+// OpenStream --
+//
+// Open a new stream to the in-guest agent.
+// The name should be built using protocol.StreamName.
+//
+func (control *Control) OpenStream(name string) (*protocol.Stream, error) {
+	agent := control.current()
+	err := agent.ready()
+	if err != nil {
+		return nil, err
+	}
+	return agent.session.Open(name)
+}
+
+//
+// Reset --
+//
+// The machine has been reset, so the agent will start
+// over with the initial handshake. Everything using the
+// current agent fails, and we wait for the new one.
+//
+func (control *Control) Reset() {
+
+	control.agent_lock.Lock()
+	old_agent := control.agent
+	agent := newGuestAgent(control.proxy)
+	control.agent = agent
+	control.agent_lock.Unlock()
+
+	// Fail the old session (and any pending handshake).
+	old_agent.conn.Close()
+
+	go control.init(agent)
 }
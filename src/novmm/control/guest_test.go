@@ -0,0 +1,230 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"noguest/protocol"
+	"sync"
+	"testing"
+)
+
+//
+// TestServer --
+//
+// A trivial in-guest RPC service.
+//
+type TestServer struct {
+	boot int
+}
+
+func (server *TestServer) Boot(command *Nop, result *int) error {
+	*result = server.boot
+	return nil
+}
+
+//
+// testGuest --
+//
+// A single boot of a fake in-guest agent.
+// Once the guest is rebooted, nothing it
+// writes will make it back to the host.
+//
+type testGuest struct {
+	boot int
+
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	output io.Writer
+
+	dead bool
+	lock sync.Mutex
+}
+
+func (guest *testGuest) Read(data []byte) (int, error) {
+	return guest.reader.Read(data)
+}
+
+func (guest *testGuest) Write(data []byte) (int, error) {
+	guest.lock.Lock()
+	defer guest.lock.Unlock()
+	if guest.dead {
+		return 0, io.ErrClosedPipe
+	}
+	return guest.output.Write(data)
+}
+
+func (guest *testGuest) Close() error {
+	guest.lock.Lock()
+	guest.dead = true
+	guest.lock.Unlock()
+	return guest.reader.Close()
+}
+
+func (guest *testGuest) run(t *testing.T) {
+
+	// Send our status.
+	_, err := guest.Write([]byte{protocol.NoGuestStatusOkay})
+	if err != nil {
+		t.Errorf("boot %d: status: %s", guest.boot, err.Error())
+		return
+	}
+
+	// Read the command.
+	buffer := make([]byte, 1, 1)
+	_, err = io.ReadFull(guest, buffer)
+	if err != nil {
+		t.Errorf("boot %d: command: %s", guest.boot, err.Error())
+		return
+	}
+	if buffer[0] != protocol.NoGuestCommandFakeInit {
+		t.Errorf("boot %d: bad command %d", guest.boot, buffer[0])
+		return
+	}
+
+	// Serve RPCs.
+	session := protocol.NewSession(guest, false)
+	server := rpc.NewServer()
+	server.Register(&TestServer{boot: guest.boot})
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(stream))
+	}
+}
+
+//
+// testProxy --
+//
+// Stands in for the console proxy. The guest side
+// is replaced each time the guest is rebooted.
+//
+type testProxy struct {
+	reader *io.PipeReader
+	writer *io.PipeWriter
+
+	guest *testGuest
+	lock  sync.Mutex
+}
+
+func newTestProxy() *testProxy {
+	reader, writer := io.Pipe()
+	return &testProxy{reader: reader, writer: writer}
+}
+
+func (proxy *testProxy) Read(data []byte) (int, error) {
+	return proxy.reader.Read(data)
+}
+
+func (proxy *testProxy) Write(data []byte) (int, error) {
+	proxy.lock.Lock()
+	guest := proxy.guest
+	proxy.lock.Unlock()
+	return guest.writer.Write(data)
+}
+
+func (proxy *testProxy) Close() error {
+	return nil
+}
+
+func (proxy *testProxy) reboot() *testGuest {
+
+	proxy.lock.Lock()
+	old_guest := proxy.guest
+	reader, writer := io.Pipe()
+	guest := &testGuest{
+		reader: reader,
+		writer: writer,
+		output: proxy.writer,
+	}
+	if old_guest != nil {
+		guest.boot = old_guest.boot + 1
+	}
+	proxy.guest = guest
+	proxy.lock.Unlock()
+
+	if old_guest != nil {
+		old_guest.Close()
+	}
+	return guest
+}
+
+func checkBoot(t *testing.T, control *Control, boot int) {
+
+	client, err := control.Ready()
+	if err != nil {
+		t.Fatalf("boot %d: ready: %s", boot, err.Error())
+	}
+	var result int
+	err = client.Call("TestServer.Boot", &Nop{}, &result)
+	if err != nil {
+		t.Fatalf("boot %d: call: %s", boot, err.Error())
+	}
+	if result != boot {
+		t.Fatalf("boot %d: got boot %d", boot, result)
+	}
+
+	// Streams should work as well (i.e. for run).
+	client, err = control.NewClient()
+	if err != nil {
+		t.Fatalf("boot %d: new client: %s", boot, err.Error())
+	}
+	defer client.Close()
+	err = client.Call("TestServer.Boot", &Nop{}, &result)
+	if err != nil {
+		t.Fatalf("boot %d: stream call: %s", boot, err.Error())
+	}
+}
+
+func TestGuestReset(t *testing.T) {
+
+	proxy := newTestProxy()
+	guest := proxy.reboot()
+
+	control := &Control{
+		proxy:  proxy,
+		events: NewEvents(),
+		agent:  newGuestAgent(proxy),
+	}
+	go control.pump()
+	go control.init(control.agent)
+	go guest.run(t)
+
+	checkBoot(t, control, 0)
+	old_client, _ := control.Ready()
+
+	// Reboot the guest (twice).
+	// NOTE: The reset happens before the guest is
+	// able to run again, as it does in the main loop.
+	for boot := 1; boot <= 2; boot += 1 {
+		guest = proxy.reboot()
+		control.Reset()
+		go guest.run(t)
+
+		checkBoot(t, control, boot)
+
+		// The old client should be dead.
+		var result int
+		err := old_client.Call("TestServer.Boot", &Nop{}, &result)
+		if err == nil {
+			t.Fatalf("boot %d: old client still works", boot)
+		}
+		old_client, _ = control.Ready()
+	}
+}
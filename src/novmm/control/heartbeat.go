@@ -0,0 +1,174 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"net/rpc"
+	noguest "noguest/rpc"
+	"novmm/platform"
+	"sync"
+	"time"
+)
+
+//
+// How often we check on the guest, and how
+// long we wait for it to respond each time.
+//
+var HeartbeatInterval = 5 * time.Second
+var HeartbeatTimeout = 5 * time.Second
+
+//
+// Heartbeat --
+//
+// Tracks the health of the in-guest agent.
+//
+// Once the agent is ready, we periodically send it a
+// heartbeat request. If it fails to respond in time, it
+// is considered stalled until the next successful one.
+//
+// No heartbeats are counted while the VM is stopped (i.e.
+// for a snapshot, a migration or the debugger), or if it
+// was paused at any point during the heartbeat, as the
+// guest can't possibly respond.
+//
+type Heartbeat struct {
+	// The last successful heartbeat.
+	last time.Time
+
+	// The guest uptime (as of the last).
+	uptime int64
+
+	// Consecutive failures.
+	misses int
+
+	// The last error (if any).
+	err error
+
+	// Our event stream.
+	events *Events
+
+	// Our underlying Vm object.
+	vm *platform.Vm
+
+	// A heartbeat that has not yet been answered.
+	// We wait on this rather than sending another, so
+	// a wedged guest doesn't pile up calls on the client.
+	pending        *rpc.Call
+	pending_client *rpc.Client
+
+	lock sync.Mutex
+}
+
+func NewHeartbeat(events *Events, vm *platform.Vm) *Heartbeat {
+	return &Heartbeat{events: events, vm: vm}
+}
+
+func (heartbeat *Heartbeat) Run(guest func() (*rpc.Client, error)) {
+
+	for {
+		heartbeat.check(guest)
+		time.Sleep(HeartbeatInterval)
+	}
+}
+
+func (heartbeat *Heartbeat) check(guest func() (*rpc.Client, error)) {
+
+	generation := heartbeat.vm.PauseGeneration()
+	if heartbeat.vm.IsStopped() {
+		return
+	}
+
+	// NOTE: The client is fetched each time, as
+	// it is replaced whenever the guest is reset.
+	client, err := guest()
+	if err != nil {
+		heartbeat.update(0, err)
+		return
+	}
+	uptime, err := heartbeat.beat(client)
+
+	// If we were paused in the meantime, then
+	// the guest may not have had a chance.
+	if err != nil &&
+		(heartbeat.vm.PauseGeneration() != generation ||
+			heartbeat.vm.IsStopped()) {
+		return
+	}
+	heartbeat.update(uptime, err)
+}
+
+func (heartbeat *Heartbeat) beat(client *rpc.Client) (int64, error) {
+
+	// Still waiting on the last one?
+	// If the client has changed (i.e. the guest was
+	// reset), then the old call will fail on its own.
+	call := heartbeat.pending
+	if call == nil || heartbeat.pending_client != client {
+		var command noguest.HeartbeatCommand
+		var result noguest.HeartbeatResult
+		call = client.Go("Server.Heartbeat", &command, &result, make(chan *rpc.Call, 1))
+	}
+	heartbeat.pending = nil
+	heartbeat.pending_client = nil
+
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return 0, call.Error
+		}
+		return call.Reply.(*noguest.HeartbeatResult).Uptime, nil
+	case <-time.After(HeartbeatTimeout):
+		heartbeat.pending = call
+		heartbeat.pending_client = client
+		return 0, GuestNotResponding
+	}
+}
+
+func (heartbeat *Heartbeat) update(uptime int64, err error) {
+
+	heartbeat.lock.Lock()
+	was_stalled := heartbeat.misses > 0
+	if err == nil {
+		heartbeat.last = time.Now()
+		heartbeat.uptime = uptime
+		heartbeat.misses = 0
+	} else {
+		heartbeat.misses += 1
+	}
+	heartbeat.err = err
+	is_stalled := heartbeat.misses > 0
+	heartbeat.lock.Unlock()
+
+	// Let everyone know about changes.
+	if is_stalled && !was_stalled {
+		heartbeat.events.Send(Event{
+			Type:  EventGuestStalled,
+			Error: err.Error()})
+	} else if was_stalled && !is_stalled {
+		heartbeat.events.Send(Event{Type: EventGuestHealthy})
+	}
+}
+
+//
+// Status --
+//
+// Returns the last heartbeat, the guest uptime at that
+// time, the number of consecutive misses and the last error.
+//
+func (heartbeat *Heartbeat) Status() (time.Time, int64, int, error) {
+	heartbeat.lock.Lock()
+	defer heartbeat.lock.Unlock()
+	return heartbeat.last, heartbeat.uptime, heartbeat.misses, heartbeat.err
+}
@@ -0,0 +1,142 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"novmm/machine"
+	"novmm/platform"
+	"syscall"
+)
+
+//
+// Guest memory access --
+//
+// Accesses are split on page boundaries, as each
+// page may be translated (and backed) differently.
+// A virtual address is translated using the given
+// vcpu, which will be paused for the duration.
+//
+
+// The largest single access we will allow.
+var MemoryMaxAccess = uint64(1024 * 1024)
+
+func accessMemory(
+	vm *platform.Vm,
+	model *machine.Model,
+	addr uint64,
+	size uint64,
+	virtual bool,
+	vcpu_id int,
+	access func(paddr platform.Paddr, data []byte, offset uint64)) error {
+
+	if size > MemoryMaxAccess {
+		return MemoryAccessTooLarge
+	}
+
+	var vcpu *platform.Vcpu
+	if virtual {
+		vcpus := vm.Vcpus()
+		if vcpu_id < 0 || vcpu_id >= len(vcpus) {
+			return syscall.EINVAL
+		}
+		vcpu = vcpus[vcpu_id]
+
+		// The translation must be done
+		// while the vcpu is not running.
+		err := vcpu.Pause(false)
+		if err != nil {
+			return err
+		}
+		defer vcpu.Unpause(false)
+	}
+
+	for offset := uint64(0); offset < size; {
+
+		// Figure out this chunk.
+		chunk := platform.PageSize - (addr+offset)%platform.PageSize
+		if chunk > size-offset {
+			chunk = size - offset
+		}
+
+		paddr := platform.Paddr(addr + offset)
+		if vcpu != nil {
+			var valid bool
+			var err error
+			paddr, valid, _, _, err = vcpu.Translate(
+				platform.Vaddr(addr + offset))
+			if err != nil {
+				return err
+			}
+			if !valid {
+				return MemoryNotMapped
+			}
+		}
+
+		// Find the backing memory.
+		data, err := model.Access(paddr, chunk)
+		if err != nil {
+			return err
+		}
+		access(paddr, data, offset)
+
+		offset += chunk
+	}
+
+	return nil
+}
+
+func ReadMemory(
+	vm *platform.Vm,
+	model *machine.Model,
+	addr uint64,
+	size uint64,
+	virtual bool,
+	vcpu int) ([]byte, error) {
+
+	result := make([]byte, size, size)
+	err := accessMemory(
+		vm,
+		model,
+		addr,
+		size,
+		virtual,
+		vcpu,
+		func(paddr platform.Paddr, data []byte, offset uint64) {
+			copy(result[offset:], data)
+		})
+
+	return result, err
+}
+
+func WriteMemory(
+	vm *platform.Vm,
+	model *machine.Model,
+	addr uint64,
+	input []byte,
+	virtual bool,
+	vcpu int) error {
+
+	return accessMemory(
+		vm,
+		model,
+		addr,
+		uint64(len(input)),
+		virtual,
+		vcpu,
+		func(paddr platform.Paddr, data []byte, offset uint64) {
+			copy(data, input[offset:])
+			model.MarkDirty(paddr, uint64(len(data)))
+		})
+}
@@ -0,0 +1,209 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"fmt"
+	"io"
+	"novmm/machine"
+	"novmm/platform"
+	"sync/atomic"
+)
+
+//
+// VcpuMetrics --
+//
+// Exit counts for a single vcpu.
+// These are updated atomically by the vcpu loop.
+//
+type VcpuMetrics struct {
+	Id int `json:"id"`
+
+	Pio      uint64 `json:"pio"`
+	Mmio     uint64 `json:"mmio"`
+	Debug    uint64 `json:"debug"`
+	Shutdown uint64 `json:"shutdown"`
+	Other    uint64 `json:"other"`
+}
+
+func (metrics *VcpuMetrics) Exit(exit error) {
+
+	switch exit.(type) {
+	case *platform.ExitPio:
+		atomic.AddUint64(&metrics.Pio, 1)
+	case *platform.ExitMmio:
+		atomic.AddUint64(&metrics.Mmio, 1)
+	case *platform.ExitDebug:
+		atomic.AddUint64(&metrics.Debug, 1)
+	case *platform.ExitShutdown:
+		atomic.AddUint64(&metrics.Shutdown, 1)
+	default:
+		atomic.AddUint64(&metrics.Other, 1)
+	}
+}
+
+func (metrics *VcpuMetrics) snapshot() VcpuMetrics {
+	return VcpuMetrics{
+		Id:       metrics.Id,
+		Pio:      atomic.LoadUint64(&metrics.Pio),
+		Mmio:     atomic.LoadUint64(&metrics.Mmio),
+		Debug:    atomic.LoadUint64(&metrics.Debug),
+		Shutdown: atomic.LoadUint64(&metrics.Shutdown),
+		Other:    atomic.LoadUint64(&metrics.Other),
+	}
+}
+
+//
+// DeviceMetrics --
+//
+// I/O counts for a single device.
+//
+type DeviceMetrics struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+
+	machine.DeviceStats
+
+	// Virtio devices only.
+	Channels []machine.VirtioChannelStats `json:"channels,omitempty"`
+}
+
+//
+// Metrics --
+//
+// All our runtime metrics.
+//
+type Metrics struct {
+	Vcpus   []VcpuMetrics   `json:"vcpus"`
+	Devices []DeviceMetrics `json:"devices"`
+}
+
+type virtioStats interface {
+	ChannelStats() []machine.VirtioChannelStats
+}
+
+func NewVcpuMetrics(vm *platform.Vm) []*VcpuMetrics {
+
+	metrics := make([]*VcpuMetrics, 0, len(vm.Vcpus()))
+	for _, vcpu := range vm.Vcpus() {
+		metrics = append(metrics, &VcpuMetrics{Id: int(vcpu.Id)})
+	}
+
+	return metrics
+}
+
+func CollectMetrics(
+	vcpus []*VcpuMetrics,
+	model *machine.Model) Metrics {
+
+	metrics := Metrics{
+		Vcpus:   make([]VcpuMetrics, 0, len(vcpus)),
+		Devices: make([]DeviceMetrics, 0, 0),
+	}
+
+	for _, vcpu := range vcpus {
+		metrics.Vcpus = append(metrics.Vcpus, vcpu.snapshot())
+	}
+
+	for _, device := range model.Devices() {
+		stats := device.Stats()
+		device_metrics := DeviceMetrics{
+			Name:   device.Name(),
+			Driver: device.Driver(),
+			DeviceStats: machine.DeviceStats{
+				Reads:  atomic.LoadUint64(&stats.Reads),
+				Writes: atomic.LoadUint64(&stats.Writes),
+			},
+		}
+		if virtio, ok := device.(virtioStats); ok {
+			device_metrics.Channels = virtio.ChannelStats()
+		}
+		metrics.Devices = append(metrics.Devices, device_metrics)
+	}
+
+	return metrics
+}
+
+func (metrics *Metrics) WritePrometheus(output io.Writer) error {
+
+	lines := make([]string, 0, 0)
+	metric := func(name string, help string, metric_type string) {
+		lines = append(lines,
+			fmt.Sprintf("# HELP %s %s", name, help),
+			fmt.Sprintf("# TYPE %s %s", name, metric_type))
+	}
+	value := func(name string, labels string, value interface{}) {
+		lines = append(lines, fmt.Sprintf("%s{%s} %d", name, labels, value))
+	}
+
+	metric("novm_vcpu_exits_total", "Vcpu exits by type.", "counter")
+	for _, vcpu := range metrics.Vcpus {
+		labels := func(exit_type string) string {
+			return fmt.Sprintf("vcpu=\"%d\",type=%q", vcpu.Id, exit_type)
+		}
+		value("novm_vcpu_exits_total", labels("pio"), vcpu.Pio)
+		value("novm_vcpu_exits_total", labels("mmio"), vcpu.Mmio)
+		value("novm_vcpu_exits_total", labels("debug"), vcpu.Debug)
+		value("novm_vcpu_exits_total", labels("shutdown"), vcpu.Shutdown)
+		value("novm_vcpu_exits_total", labels("other"), vcpu.Other)
+	}
+
+	metric("novm_device_io_total", "Device I/O requests.", "counter")
+	for _, device := range metrics.Devices {
+		labels := fmt.Sprintf("device=%q,driver=%q", device.Name, device.Driver)
+		value("novm_device_io_total", labels+",op=\"read\"", device.Reads)
+		value("novm_device_io_total", labels+",op=\"write\"", device.Writes)
+	}
+
+	channels := func(name string, get func(machine.VirtioChannelStats) interface{}) {
+		for _, device := range metrics.Devices {
+			for _, channel := range device.Channels {
+				value(name, fmt.Sprintf(
+					"device=%q,channel=\"%d\"",
+					device.Name,
+					channel.Channel), get(channel))
+			}
+		}
+	}
+
+	metric(
+		"novm_virtio_buffers_consumed_total",
+		"Virtio buffers consumed.",
+		"counter")
+	channels(
+		"novm_virtio_buffers_consumed_total",
+		func(channel machine.VirtioChannelStats) interface{} {
+			return channel.Consumed
+		})
+
+	metric(
+		"novm_virtio_buffers_outstanding",
+		"Virtio buffers outstanding.",
+		"gauge")
+	channels(
+		"novm_virtio_buffers_outstanding",
+		func(channel machine.VirtioChannelStats) interface{} {
+			return channel.Outstanding
+		})
+
+	for _, line := range lines {
+		_, err := io.WriteString(output, line+"\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,448 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"novmm/machine"
+	"novmm/platform"
+	"novmm/utils"
+	"sort"
+	"syscall"
+)
+
+//
+// Migration --
+//
+// The migration stream is a sequence of memory runs,
+// each prefixed with a fixed header (the offset in the
+// user memory backing file, and the length of the run).
+// A run with zero length marks the end of memory, and is
+// followed by the JSON-encoded State of the machine.
+//
+// The receiver replies with a single JSON value once the
+// machine is loaded: null on success or an error string.
+//
+// Memory is copied first while the guest is running. We
+// keep copying pages that have been dirtied since the last
+// round (by the guest, or by our devices) until the set is
+// small enough, then stop everything and copy the rest.
+//
+
+// Stop pre-copying when fewer pages than this are dirty.
+var MigrateDirtyPages = 256
+
+// Never do more than this many pre-copy rounds.
+var MigrateMaxRounds = 16
+
+// The largest run we will send.
+var MigrateMaxRun = uint64(1024 * 1024)
+
+type migrateHeader struct {
+	Offset uint64
+	Length uint64
+}
+
+//
+// Fields in device state which refer to host resources.
+// When loading state from another process, these are not
+// meaningful and must come from the receiver's own state.
+//
+var hostLocalFields = []string{"fd", "offset"}
+
+func findUserMemory(model *machine.Model) (*machine.UserMemory, error) {
+
+	for _, device := range model.Devices() {
+		user, ok := device.(*machine.UserMemory)
+		if ok {
+			return user, nil
+		}
+	}
+
+	return nil, machine.UserMemoryNotFound
+}
+
+func sendRun(
+	conn io.Writer,
+	user *machine.UserMemory,
+	offset uint64,
+	length uint64,
+	buffer []byte) error {
+
+	for length > 0 {
+		run := length
+		if run > uint64(len(buffer)) {
+			run = uint64(len(buffer))
+		}
+
+		// Write the header.
+		header := migrateHeader{offset, run}
+		err := binary.Write(conn, binary.LittleEndian, &header)
+		if err != nil {
+			return err
+		}
+
+		// Write the data.
+		n, err := user.ReadAt(buffer[:run], int64(offset))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		_, err = conn.Write(buffer[:n])
+		if err != nil {
+			return err
+		}
+		if uint64(n) != run {
+			return io.ErrUnexpectedEOF
+		}
+
+		offset += run
+		length -= run
+	}
+
+	return nil
+}
+
+func sendPages(
+	conn io.Writer,
+	user *machine.UserMemory,
+	pages []platform.Paddr,
+	buffer []byte) error {
+
+	start := uint64(0)
+	length := uint64(0)
+
+	for _, page := range pages {
+
+		// Is this user memory?
+		// Other memory (i.e. ACPI) is part of device state.
+		offset, ok := user.Lookup(page)
+		if !ok {
+			continue
+		}
+
+		// Can we extend the current run?
+		if length > 0 && offset == start+length {
+			length += platform.PageSize
+			continue
+		}
+
+		// Flush the current run.
+		if length > 0 {
+			err := sendRun(conn, user, start, length, buffer)
+			if err != nil {
+				return err
+			}
+		}
+		start = offset
+		length = platform.PageSize
+	}
+
+	if length > 0 {
+		return sendRun(conn, user, start, length, buffer)
+	}
+
+	return nil
+}
+
+func dirtyPages(vm *platform.Vm) ([]platform.Paddr, error) {
+
+	bitmaps, err := vm.GetDirtyLog()
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]platform.Paddr, 0, 0)
+	for start, bitmap := range bitmaps {
+		pages = append(pages, bitmap.Pages(start)...)
+	}
+	sort.Sort(platform.PaddrList(pages))
+
+	return pages, nil
+}
+
+func Migrate(
+	vm *platform.Vm,
+	model *machine.Model,
+	conn io.ReadWriter) error {
+
+	user, err := findUserMemory(model)
+	if err != nil {
+		return err
+	}
+	buffer := make([]byte, MigrateMaxRun, MigrateMaxRun)
+
+	// Start tracking dirty pages.
+	// We enable this before the initial copy, so
+	// that anything written during the copy is sent
+	// again in the next round.
+	model.Track(true)
+	defer model.Track(false)
+	for _, slot := range vm.MemorySlots() {
+		err = vm.EnableDirtyLog(slot.Slot)
+		if err != nil {
+			return err
+		}
+		defer vm.DisableDirtyLog(slot.Slot)
+	}
+
+	// Send all memory.
+	log.Printf("Migration: copying %d bytes...", user.Size())
+	err = sendRun(conn, user, 0, user.Size(), buffer)
+	if err != nil {
+		return err
+	}
+
+	// Send dirty pages until we converge.
+	for round := 0; round < MigrateMaxRounds; round += 1 {
+		dirty, err := dirtyPages(vm)
+		if err != nil {
+			return err
+		}
+		log.Printf(
+			"Migration: round %d, %d dirty pages.",
+			round,
+			len(dirty))
+		err = sendPages(conn, user, dirty, buffer)
+		if err != nil {
+			return err
+		}
+		if len(dirty) < MigrateDirtyPages {
+			break
+		}
+	}
+
+	// Stop everything.
+	// NOTE: On success, we leave the machine paused.
+	// The guest is now running on the other side, and
+	// it must not continue to run here as well.
+	//
+	// Unlike a snapshot, we don't freeze the guest
+	// filesystems first. The guest (including its page
+	// cache) continues on the other side with the same
+	// disks, so nothing is lost. The guest would also
+	// arrive frozen, and only the receiver could thaw it.
+	err = vm.Pause(false)
+	if err != nil {
+		return err
+	}
+	err = model.Pause(false)
+	if err != nil {
+		vm.Unpause(false)
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			model.Unpause(false)
+			vm.Unpause(false)
+		}
+	}()
+
+	// Grab our state.
+	state, err := SaveState(vm, model, nil)
+	if err != nil {
+		return err
+	}
+
+	// Send the remaining pages.
+	dirty, err := dirtyPages(vm)
+	if err != nil {
+		return err
+	}
+	dirty = append(dirty, model.Collect()...)
+	sort.Sort(platform.PaddrList(dirty))
+	log.Printf("Migration: stopped, %d dirty pages.", len(dirty))
+	err = sendPages(conn, user, dirty, buffer)
+	if err != nil {
+		return err
+	}
+
+	// Send our terminator & state.
+	err = binary.Write(conn, binary.LittleEndian, &migrateHeader{})
+	if err != nil {
+		return err
+	}
+	err = WriteState(conn, &state)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the other side.
+	var result *string
+	decoder := utils.NewDecoder(conn)
+	err = decoder.Decode(&result)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		return MigrationFailed(*result)
+	}
+
+	log.Printf("Migration: complete.")
+	done = true
+	return nil
+}
+
+func deviceData(info *machine.DeviceInfo) (map[string]interface{}, bool) {
+	data, ok := info.Data.(map[string]interface{})
+	return data, ok
+}
+
+func localMemory(local *State) (int, int64, error) {
+
+	for i, info := range local.Devices {
+		if info.Driver != "user-memory" {
+			continue
+		}
+		data, ok := deviceData(&local.Devices[i])
+		if !ok {
+			break
+		}
+
+		// Extract our fields.
+		fd, ok := data["fd"].(json.Number)
+		if !ok {
+			break
+		}
+		fdval, err := fd.Int64()
+		if err != nil {
+			return -1, 0, err
+		}
+		offset := int64(0)
+		if offset_val, ok := data["offset"].(json.Number); ok {
+			offset, err = offset_val.Int64()
+			if err != nil {
+				return -1, 0, err
+			}
+		}
+
+		return int(fdval), offset, nil
+	}
+
+	return -1, 0, machine.UserMemoryNotFound
+}
+
+func hasHostFd(data map[string]interface{}) bool {
+	_, ok := data["fd"]
+	return ok
+}
+
+func (state *State) mergeLocal(local *State) error {
+
+	for i, info := range state.Devices {
+		data, ok := deviceData(&state.Devices[i])
+		if !ok {
+			continue
+		}
+
+		// Any device which holds a host descriptor must
+		// be matched locally. The number we were given is
+		// meaningless (or worse, some other file) here.
+		if hasHostFd(data) {
+			found := false
+			for _, local_info := range local.Devices {
+				if local_info.Name == info.Name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return MissingHostResources(info.Name)
+			}
+		}
+
+		for j, local_info := range local.Devices {
+			if local_info.Name != info.Name {
+				continue
+			}
+			local_data, ok := deviceData(&local.Devices[j])
+			if !ok {
+				continue
+			}
+
+			// Take all host resources from local state.
+			for _, field := range hostLocalFields {
+				if value, ok := local_data[field]; ok {
+					data[field] = value
+				} else {
+					delete(data, field)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func ReceiveMigration(conn io.Reader, local *State) (*State, error) {
+
+	fd, base, err := localMemory(local)
+	if err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, MigrateMaxRun, MigrateMaxRun)
+
+	// Read all memory.
+	for {
+		var header migrateHeader
+		err := binary.Read(conn, binary.LittleEndian, &header)
+		if err != nil {
+			return nil, err
+		}
+		if header.Length == 0 {
+			break
+		}
+		if header.Length > uint64(len(buffer)) {
+			return nil, MigrationInvalid
+		}
+
+		_, err = io.ReadFull(conn, buffer[:header.Length])
+		if err != nil {
+			return nil, err
+		}
+		_, err = syscall.Pwrite(
+			fd,
+			buffer[:header.Length],
+			base+int64(header.Offset))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Read our state.
+	state, err := ReadState(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use our own host resources.
+	err = state.mergeLocal(local)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func FinishMigration(conn io.Writer, err error) error {
+
+	encoder := utils.NewEncoder(conn)
+	if err != nil {
+		return encoder.Encode(err.Error())
+	}
+	return encoder.Encode(nil)
+}
@@ -19,9 +19,17 @@ package control
 //
 
 func (rpc *Rpc) Pause(nopin *Nop, nopout *Nop) error {
-	return rpc.vm.Pause(true)
+	err := rpc.vm.Pause(true)
+	if err == nil {
+		rpc.events.Send(Event{Type: EventPause})
+	}
+	return err
 }
 
 func (rpc *Rpc) Unpause(nopin *Nop, nopout *Nop) error {
-	return rpc.vm.Unpause(true)
+	err := rpc.vm.Unpause(true)
+	if err == nil {
+		rpc.events.Send(Event{Type: EventUnpause})
+	}
+	return err
 }
@@ -57,8 +57,18 @@ func (rpc *Rpc) Device(settings *DeviceSettings, nop *Nop) error {
 
 			if settings.Paused {
 				err = device.Pause(true)
+				if err == nil {
+					rpc.events.Send(Event{
+						Type:   EventPause,
+						Device: device.Name()})
+				}
 			} else {
 				err = device.Unpause(true)
+				if err == nil {
+					rpc.events.Send(Event{
+						Type:   EventUnpause,
+						Device: device.Name()})
+				}
 			}
 
 			if err != nil {
@@ -0,0 +1,81 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+//
+// Port forwarding rpcs.
+//
+
+type ForwardSettings struct {
+	// The host network ("tcp" or "unix").
+	Network string `json:"network"`
+
+	// The host address to listen on.
+	Address string `json:"address"`
+
+	// The guest network ("tcp" or "unix").
+	GuestNetwork string `json:"guest-network"`
+
+	// The guest-local address to connect to.
+	GuestAddress string `json:"guest-address"`
+}
+
+type ForwardResult struct {
+	// The forward id (for Unforward).
+	Id int `json:"id"`
+
+	// The actual host address.
+	Address string `json:"address"`
+}
+
+func (rpc *Rpc) Forward(
+	settings *ForwardSettings,
+	result *ForwardResult) error {
+
+	network := settings.Network
+	if network == "" {
+		network = "tcp"
+	}
+	guest_network := settings.GuestNetwork
+	if guest_network == "" {
+		guest_network = "tcp"
+	}
+
+	forward, err := NewForward(
+		network,
+		settings.Address,
+		guest_network,
+		settings.GuestAddress,
+		rpc.open)
+	if err != nil {
+		return err
+	}
+
+	result.Id = rpc.forwards.Add(forward)
+	result.Address = forward.Addr().String()
+	return nil
+}
+
+type UnforwardSettings struct {
+	// The forward id.
+	Id int `json:"id"`
+}
+
+func (rpc *Rpc) Unforward(
+	settings *UnforwardSettings,
+	nop *Nop) error {
+
+	return rpc.forwards.Remove(settings.Id)
+}
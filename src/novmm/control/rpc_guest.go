@@ -0,0 +1,122 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	noguest "noguest/rpc"
+	"time"
+)
+
+//
+// Guest status rpcs.
+//
+
+type GuestStatusSettings struct {
+	// Also query the guest for system info?
+	Info bool `json:"info"`
+}
+
+type GuestStatusResult struct {
+	// Is the agent healthy?
+	// This means that it is ready, and the
+	// last heartbeat was answered in time.
+	Healthy bool `json:"healthy"`
+
+	// The last answered heartbeat.
+	LastHeartbeat *time.Time `json:"last-heartbeat,omitempty"`
+
+	// The guest uptime (in seconds, as of the last).
+	Uptime int64 `json:"uptime"`
+
+	// Consecutive missed heartbeats.
+	Misses int `json:"misses"`
+
+	// The last error (if any).
+	Error string `json:"error,omitempty"`
+
+	// System info (if requested).
+	Info *noguest.InfoResult `json:"info,omitempty"`
+}
+
+func (rpc *Rpc) GuestStatus(
+	settings *GuestStatusSettings,
+	result *GuestStatusResult) error {
+
+	last, uptime, misses, err := rpc.heartbeat.Status()
+	if !last.IsZero() {
+		result.LastHeartbeat = &last
+	}
+	result.Uptime = uptime
+	result.Misses = misses
+	result.Healthy = !last.IsZero() && misses == 0
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if settings.Info {
+		client, err := rpc.guest()
+		if err != nil {
+			return err
+		}
+		var info noguest.InfoCommand
+		result.Info = new(noguest.InfoResult)
+		return client.Call("Server.Info", &info, result.Info)
+	}
+
+	return nil
+}
+
+//
+// Guest process rpcs.
+//
+// These are simply passed through to the agent, so
+// that a supervisor can see and manage what is running.
+//
+
+func (rpc *Rpc) guestCall(method string, args interface{}, reply interface{}) error {
+	client, err := rpc.guest()
+	if err != nil {
+		return err
+	}
+	return client.Call(method, args, reply)
+}
+
+func (rpc *Rpc) GuestList(
+	list *noguest.ListCommand,
+	result *noguest.ListResult) error {
+
+	return rpc.guestCall("Server.List", list, result)
+}
+
+func (rpc *Rpc) GuestKill(
+	kill *noguest.KillCommand,
+	result *noguest.KillResult) error {
+
+	return rpc.guestCall("Server.Kill", kill, result)
+}
+
+func (rpc *Rpc) GuestRelease(
+	release *noguest.ReleaseCommand,
+	result *noguest.ReleaseResult) error {
+
+	return rpc.guestCall("Server.Release", release, result)
+}
+
+func (rpc *Rpc) GuestRetain(
+	retain *noguest.RetainCommand,
+	result *noguest.RetainResult) error {
+
+	return rpc.guestCall("Server.Retain", retain, result)
+}
@@ -0,0 +1,173 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"encoding/json"
+	"novmm/machine"
+	"syscall"
+	"time"
+)
+
+//
+// Hot-plug rpcs.
+//
+// Devices are attached and the guest is notified via
+// ACPI, so it will scan the new slot on its own. Devices
+// which need a host descriptor (a tap or a disk) must have
+// it passed alongside the request on the control socket
+// (SCM_RIGHTS). The "fd" in the device data is then the
+// index of the descriptor amongst those passed on the
+// connection, not a descriptor number.
+//
+
+type AddDeviceResult struct {
+	// The assigned PCI slot.
+	Slot int `json:"slot"`
+}
+
+func (rpc *Rpc) claimFd(info *machine.DeviceInfo) (int, error) {
+
+	data, ok := deviceData(info)
+	if !ok {
+		return -1, nil
+	}
+	value, ok := data["fd"]
+	if !ok {
+		return -1, nil
+	}
+	if rpc.conn == nil {
+		return -1, FdNotPassed
+	}
+
+	var index int
+	switch number := value.(type) {
+	case float64:
+		index = int(number)
+	case json.Number:
+		index64, err := number.Int64()
+		if err != nil {
+			return -1, err
+		}
+		index = int(index64)
+	default:
+		return -1, FdNotPassed
+	}
+
+	fd, err := rpc.conn.Claim(index)
+	if err != nil {
+		return -1, err
+	}
+	data["fd"] = fd
+	return fd, nil
+}
+
+func (rpc *Rpc) AddDevice(
+	info *machine.DeviceInfo,
+	result *AddDeviceResult) error {
+
+	// Grab any passed descriptor.
+	fd, err := rpc.claimFd(info)
+	if err != nil {
+		return err
+	}
+
+	// Stop all vcpus while we change the model.
+	err = rpc.vm.Pause(false)
+	if err != nil {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+		return err
+	}
+
+	device, slot, err := rpc.model.AddDevice(rpc.vm, *info)
+	rpc.vm.Unpause(false)
+	if device == nil && fd >= 0 {
+		syscall.Close(fd)
+	}
+	if err != nil {
+		return err
+	}
+	result.Slot = slot
+
+	rpc.events.Send(Event{
+		Type:   EventDeviceAdded,
+		Device: device.Name()})
+
+	return nil
+}
+
+type RemoveDeviceSettings struct {
+	// The device name.
+	Name string `json:"name"`
+
+	// How long to wait for the guest (seconds).
+	Timeout int `json:"timeout"`
+}
+
+//
+// The default time we will wait for the guest
+// to release a device before giving up.
+//
+var RemoveDeviceTimeout = 10 * time.Second
+
+func (rpc *Rpc) RemoveDevice(
+	settings *RemoveDeviceSettings,
+	nop *Nop) error {
+
+	// Ask the guest to eject it.
+	err := rpc.model.EjectDevice(settings.Name)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the driver to let go.
+	timeout := RemoveDeviceTimeout
+	if settings.Timeout > 0 {
+		timeout = time.Duration(settings.Timeout) * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		released, err := rpc.model.IsReleased(settings.Name)
+		if err != nil {
+			return err
+		}
+		if released {
+			break
+		}
+		if time.Now().After(deadline) {
+			return DeviceNotReleased
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Pull it out of the model.
+	err = rpc.vm.Pause(false)
+	if err != nil {
+		return err
+	}
+	err = rpc.model.RemoveDevice(rpc.vm, settings.Name)
+	rpc.vm.Unpause(false)
+	if err != nil {
+		return err
+	}
+
+	rpc.events.Send(Event{
+		Type:   EventDeviceRemoved,
+		Device: settings.Name})
+
+	return nil
+}
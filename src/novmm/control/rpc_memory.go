@@ -0,0 +1,72 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+//
+// Memory rpcs.
+//
+
+type MemorySettings struct {
+	// The guest address.
+	Address uint64 `json:"address"`
+
+	// Is this a virtual address?
+	// If so, it is translated using the given vcpu.
+	Virtual bool `json:"virtual"`
+	Vcpu    int  `json:"vcpu"`
+
+	// The size (for reads).
+	Size uint64 `json:"size"`
+
+	// The data (for writes).
+	Data []byte `json:"data"`
+}
+
+type MemoryResult struct {
+	// The data read.
+	Data []byte `json:"data"`
+}
+
+func (rpc *Rpc) ReadMemory(
+	settings *MemorySettings,
+	result *MemoryResult) error {
+
+	data, err := ReadMemory(
+		rpc.vm,
+		rpc.model,
+		settings.Address,
+		settings.Size,
+		settings.Virtual,
+		settings.Vcpu)
+	if err != nil {
+		return err
+	}
+
+	result.Data = data
+	return nil
+}
+
+func (rpc *Rpc) WriteMemory(
+	settings *MemorySettings,
+	nop *Nop) error {
+
+	return WriteMemory(
+		rpc.vm,
+		rpc.model,
+		settings.Address,
+		settings.Data,
+		settings.Virtual,
+		settings.Vcpu)
+}
@@ -0,0 +1,57 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"net"
+)
+
+//
+// Migration rpcs.
+//
+
+type MigrateSettings struct {
+	// The network (i.e. unix or tcp).
+	Network string `json:"network"`
+
+	// The address of the receiving novmm.
+	Address string `json:"address"`
+}
+
+func (rpc *Rpc) Migrate(settings *MigrateSettings, nop *Nop) error {
+
+	network := settings.Network
+	if network == "" {
+		network = "unix"
+	}
+
+	// Connect to the receiver.
+	conn, err := net.Dial(network, settings.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = Migrate(rpc.vm, rpc.model, conn)
+	if err != nil {
+		return err
+	}
+
+	// The guest is running elsewhere now.
+	// We let the main loop know, and it will
+	// exit (releasing memory and devices).
+	rpc.migrated <- true
+	return nil
+}
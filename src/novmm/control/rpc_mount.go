@@ -0,0 +1,54 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	noguest "noguest/rpc"
+)
+
+//
+// Guest mount rpcs.
+//
+
+type MountSettings struct {
+	// The filesystem device (e.g. as per DeviceInfo).
+	// If set, the source is the device's 9p tag.
+	Device string `json:"device"`
+
+	noguest.MountCommand
+}
+
+func (rpc *Rpc) Mount(
+	settings *MountSettings,
+	result *noguest.MountResult) error {
+
+	if settings.Device != "" {
+		tag, err := rpc.model.FsTag(settings.Device)
+		if err != nil {
+			return err
+		}
+		settings.Source = tag
+		settings.Type = "9p"
+	}
+
+	return rpc.guestCall("Server.Mount", &settings.MountCommand, result)
+}
+
+func (rpc *Rpc) Unmount(
+	unmount *noguest.UnmountCommand,
+	result *noguest.UnmountResult) error {
+
+	return rpc.guestCall("Server.Unmount", unmount, result)
+}
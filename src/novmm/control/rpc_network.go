@@ -0,0 +1,69 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	noguest "noguest/rpc"
+)
+
+//
+// Guest network rpcs.
+//
+
+type NetworkInterfaceSettings struct {
+	// The network device (e.g. as per DeviceInfo).
+	// If set, the MAC is taken from the device.
+	Device string `json:"device"`
+
+	noguest.NetworkInterface
+}
+
+type NetworkSettings struct {
+	// Interfaces to configure.
+	Interfaces []NetworkInterfaceSettings `json:"interfaces"`
+
+	// Routes to add.
+	Routes []noguest.NetworkRoute `json:"routes"`
+
+	// DNS configuration.
+	Nameservers []string `json:"nameservers"`
+	Search      []string `json:"search"`
+}
+
+func (rpc *Rpc) ConfigureNetwork(
+	settings *NetworkSettings,
+	result *noguest.NetworkResult) error {
+
+	command := noguest.NetworkCommand{
+		Interfaces:  make([]noguest.NetworkInterface, 0, len(settings.Interfaces)),
+		Routes:      settings.Routes,
+		Nameservers: settings.Nameservers,
+		Search:      settings.Search,
+	}
+
+	// Match devices to the guest interfaces.
+	for _, iface := range settings.Interfaces {
+		if iface.Device != "" {
+			mac, err := rpc.model.NicMac(iface.Device)
+			if err != nil {
+				return err
+			}
+			iface.Mac = mac
+		}
+		command.Interfaces = append(command.Interfaces, iface.NetworkInterface)
+	}
+
+	return rpc.guestCall("Server.ConfigureNetwork", &command, result)
+}
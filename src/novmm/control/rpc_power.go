@@ -0,0 +1,79 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"log"
+	"time"
+)
+
+type ShutdownSettings struct {
+	// How long to wait for the guest (seconds).
+	Timeout int `json:"timeout"`
+}
+
+type ShutdownResult struct {
+	// Did we have to pull the plug?
+	Forced bool `json:"forced"`
+}
+
+//
+// The default time we will wait for the guest
+// to power off before forcing the issue.
+//
+var ShutdownTimeout = 30 * time.Second
+
+//
+// shutdown --
+//
+// Press the power button and wait for the guest to
+// power off. If it hasn't within the timeout, then
+// we power off regardless. Note that the caller will
+// generally not get to see much after this returns,
+// since the main loop exits once power is off.
+//
+func (rpc *Rpc) shutdown(timeout time.Duration) (bool, error) {
+
+	powered_off := rpc.model.PoweredOff()
+
+	err := rpc.model.PowerButton()
+	if err != nil {
+		return false, err
+	}
+	rpc.events.Send(Event{Type: EventPowerButton})
+
+	select {
+	case <-powered_off:
+		return false, nil
+
+	case <-time.After(timeout):
+		log.Printf("Guest did not power off, forcing.")
+		return true, rpc.model.PowerOff()
+	}
+}
+
+func (rpc *Rpc) Shutdown(
+	settings *ShutdownSettings,
+	result *ShutdownResult) error {
+
+	timeout := ShutdownTimeout
+	if settings.Timeout > 0 {
+		timeout = time.Duration(settings.Timeout) * time.Second
+	}
+
+	forced, err := rpc.shutdown(timeout)
+	result.Forced = forced
+	return err
+}
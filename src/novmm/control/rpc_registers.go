@@ -0,0 +1,142 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"novmm/platform"
+	"syscall"
+)
+
+//
+// Register rpcs.
+//
+// These operate on a single vcpu, which is paused
+// for the duration of the call. For any meaningful
+// inspection the vcpu should already be paused
+// (see Rpc.Vcpu), otherwise the state is stale as
+// soon as it is returned.
+//
+
+type RegisterSettings struct {
+	// Which vcpu?
+	Id int `json:"id"`
+}
+
+type RegisterState struct {
+	// Which vcpu?
+	Id int `json:"id"`
+
+	// General purpose, segment, descriptor
+	// and control registers.
+	Registers platform.Registers `json:"registers"`
+
+	// Model-specific registers.
+	Msrs []platform.Msr `json:"msrs"`
+
+	// Floating point state.
+	Fpu *platform.Fpu `json:"fpu"`
+
+	// Extended state.
+	XSave *platform.XSave `json:"xsave"`
+}
+
+func (rpc *Rpc) getVcpu(id int) (*platform.Vcpu, error) {
+	vcpus := rpc.vm.Vcpus()
+	if id < 0 || id >= len(vcpus) {
+		return nil, syscall.EINVAL
+	}
+	return vcpus[id], nil
+}
+
+func (rpc *Rpc) GetRegisters(
+	settings *RegisterSettings,
+	state *RegisterState) error {
+
+	vcpu, err := rpc.getVcpu(settings.Id)
+	if err != nil {
+		return err
+	}
+
+	err = vcpu.Pause(false)
+	if err != nil {
+		return err
+	}
+	defer vcpu.Unpause(false)
+
+	state.Id = settings.Id
+	state.Registers, err = vcpu.GetRegisters()
+	if err != nil {
+		return err
+	}
+	state.Msrs, err = vcpu.GetMsrs()
+	if err != nil {
+		return err
+	}
+	fpu, err := vcpu.GetFpuState()
+	if err != nil {
+		return err
+	}
+	state.Fpu = &fpu
+	xsave, err := vcpu.GetXSave()
+	if err != nil {
+		return err
+	}
+	state.XSave = &xsave
+
+	return nil
+}
+
+func (rpc *Rpc) SetRegisters(
+	state *RegisterState,
+	nop *Nop) error {
+
+	vcpu, err := rpc.getVcpu(state.Id)
+	if err != nil {
+		return err
+	}
+
+	err = vcpu.Pause(false)
+	if err != nil {
+		return err
+	}
+	defer vcpu.Unpause(false)
+
+	// Only the given state is set.
+	// Any nil register (or field) is left as is.
+	err = vcpu.SetRegisters(state.Registers)
+	if err != nil {
+		return err
+	}
+	if state.Msrs != nil {
+		err = vcpu.SetMsrs(state.Msrs)
+		if err != nil {
+			return err
+		}
+	}
+	if state.Fpu != nil {
+		err = vcpu.SetFpuState(*state.Fpu)
+		if err != nil {
+			return err
+		}
+	}
+	if state.XSave != nil {
+		err = vcpu.SetXSave(*state.XSave)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+//
+// Snapshot rpcs.
+//
+
+type SnapshotSettings struct {
+	// The file to write.
+	Path string `json:"path"`
+}
+
+//
+// Snapshot --
+//
+// NOTE: Unlike State, there is no option to freeze the
+// guest filesystems here. The memory image would be taken
+// while frozen, so the restored guest would come back up
+// frozen (and nothing would be there to thaw it).
+//
+func (rpc *Rpc) Snapshot(settings *SnapshotSettings, nop *Nop) error {
+	return Snapshot(rpc.vm, rpc.model, settings.Path)
+}
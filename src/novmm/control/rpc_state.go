@@ -18,9 +18,28 @@ package control
 // State-related rpcs.
 //
 
-func (rpc *Rpc) State(nop *Nop, res *State) error {
+type StateSettings struct {
+	// Freeze guest filesystems around the pause?
+	// The filesystems are thawed again before this call
+	// returns, and the state returned has no memory or
+	// disks. So this is only useful if the caller copies
+	// memory and disks itself (i.e. with the machine
+	// paused) before the call returns.
+	Freeze bool `json:"freeze"`
 
-	state, err := SaveState(rpc.vm, rpc.model)
+	// The guest mount points to freeze.
+	// (By default, all block-backed filesystems).
+	Paths []string `json:"paths"`
+}
+
+func (rpc *Rpc) State(settings *StateSettings, res *State) error {
+
+	var freezer Freezer
+	if settings.Freeze {
+		freezer = NewGuestFreezer(rpc.guest, settings.Paths)
+	}
+
+	state, err := SaveState(rpc.vm, rpc.model, freezer)
 	if err != nil {
 		return err
 	}
@@ -43,7 +62,7 @@ func (rpc *Rpc) Reload(in *Nop, out *Nop) error {
 	defer rpc.vm.Unpause(false)
 
 	// Save a copy of the current state.
-	state, err := SaveState(rpc.vm, rpc.model)
+	state, err := SaveState(rpc.vm, rpc.model, nil)
 	if err != nil {
 		return err
 	}
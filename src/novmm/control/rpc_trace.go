@@ -30,5 +30,6 @@ func (rpc *Rpc) Trace(settings *TraceSettings, nop *Nop) error {
 		rpc.tracer.Disable()
 	}
 
+	rpc.events.Send(Event{Type: EventTrace, Enabled: &settings.Enable})
 	return nil
 }
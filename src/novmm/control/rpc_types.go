@@ -15,6 +15,8 @@
 package control
 
 import (
+	"net/rpc"
+	"noguest/protocol"
 	"novmm/loader"
 	"novmm/machine"
 	"novmm/platform"
@@ -37,20 +39,69 @@ type Rpc struct {
 
 	// Our tracer.
 	tracer *loader.Tracer
+
+	// Our event stream.
+	events *Events
+
+	// Our vcpu metrics.
+	vcpus []*VcpuMetrics
+
+	// Our debugger.
+	debugger *Debugger
+
+	// Our guest client (see Control.Ready).
+	guest func() (*rpc.Client, error)
+
+	// Opens guest streams (see Control.OpenStream).
+	open func(name string) (*protocol.Stream, error)
+
+	// Our guest heartbeat.
+	heartbeat *Heartbeat
+
+	// Signalled after a successful migration.
+	migrated chan bool
+
+	// Our active port forwards.
+	forwards *Forwards
+
+	// The connection for this instance (see withConn).
+	// This provides any descriptors passed by the client.
+	conn *FdConn
 }
 
 func NewRpc(
 	model *machine.Model,
 	vm *platform.Vm,
-	tracer *loader.Tracer) *Rpc {
+	tracer *loader.Tracer,
+	events *Events,
+	vcpus []*VcpuMetrics,
+	debugger *Debugger,
+	guest func() (*rpc.Client, error),
+	open func(name string) (*protocol.Stream, error),
+	heartbeat *Heartbeat,
+	migrated chan bool) *Rpc {
 
 	return &Rpc{
-		model:  model,
-		vm:     vm,
-		tracer: tracer,
+		model:     model,
+		vm:        vm,
+		tracer:    tracer,
+		events:    events,
+		vcpus:     vcpus,
+		debugger:  debugger,
+		guest:     guest,
+		open:      open,
+		heartbeat: heartbeat,
+		migrated:  migrated,
+		forwards:  NewForwards(),
 	}
 }
 
+func (rpc *Rpc) withConn(conn *FdConn) *Rpc {
+	conn_rpc := *rpc
+	conn_rpc.conn = conn
+	return &conn_rpc
+}
+
 //
 // The Noop --
 //
@@ -15,6 +15,7 @@
 package control
 
 import (
+	"novmm/platform"
 	"syscall"
 )
 
@@ -50,12 +51,37 @@ func (rpc *Rpc) Vcpu(settings *VcpuSettings, nop *Nop) error {
 	}
 
 	// Ensure that the vcpu is paused/unpaused.
+	// We only send an event if the state changed.
 	if settings.Paused {
 		err = vcpu.Pause(true)
+		if err == nil {
+			rpc.events.VcpuEvent(EventPause, settings.Id, nil)
+		}
 	} else {
 		err = vcpu.Unpause(true)
+		if err == nil {
+			rpc.events.VcpuEvent(EventUnpause, settings.Id, nil)
+		}
 	}
 
 	// Done.
 	return err
 }
+
+type BreakpointSettings struct {
+	// Instruction breakpoints.
+	Breakpoints []platform.Vaddr `json:"breakpoints"`
+
+	// Data watchpoints.
+	Watchpoints []platform.Watchpoint `json:"watchpoints"`
+}
+
+func (rpc *Rpc) Breakpoint(settings *BreakpointSettings, nop *Nop) error {
+
+	// These replace any existing hardware breakpoints,
+	// and apply to all vcpus. When hit, the vcpu is paused
+	// and an event is sent. See Debugger.Exit().
+	return rpc.debugger.SetHardware(
+		settings.Breakpoints,
+		settings.Watchpoints)
+}
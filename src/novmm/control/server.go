@@ -15,16 +15,21 @@
 package control
 
 import (
+	"encoding/json"
+	"log"
 	"net/rpc"
 	"net/rpc/jsonrpc"
+	"noguest/protocol"
 	noguest "noguest/rpc"
 	"novmm/loader"
 	"novmm/machine"
 	"novmm/platform"
 	"novmm/utils"
 	"os"
+	"strconv"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type Control struct {
@@ -41,32 +46,86 @@ type Control struct {
 	// Our rpc server.
 	rpc *Rpc
 
-	// Our bound client (to the in-guest agent).
-	// NOTE: We have this setup as a lazy function
-	// because the guest may take some small amount of
-	// time before it's actually ready to process RPC
-	// requests. We don't want this to interfere with
-	// our ability to process our host-side requests.
-	client_res   chan error
-	client_err   error
-	client_once  sync.Once
-	client_codec rpc.ClientCodec
-	client       *rpc.Client
+	// Our event stream.
+	events *Events
+
+	// Our vcpu metrics.
+	vcpus []*VcpuMetrics
+
+	// Our gdb stub.
+	debugger *Debugger
+
+	// Our guest heartbeat.
+	heartbeat *Heartbeat
+
+	// Signalled after a successful migration.
+	migrated chan bool
+
+	// Our in-guest agent (replaced on reset).
+	agent      *guestAgent
+	agent_lock sync.Mutex
 }
 
-func (control *Control) handle(
-	conn_fd int,
-	server *rpc.Server) {
+//
+// RunControl --
+//
+// An out-of-band message in the "NOVM RUN\n" stream.
+// Only the fields which are set will be applied.
+//
+type RunControl struct {
+	// Send a signal to the process.
+	Signal int `json:"signal,omitempty"`
+
+	// Resize the terminal.
+	Rows uint16 `json:"rows,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+
+	// Close standard input.
+	CloseStdin bool `json:"close-stdin,omitempty"`
+}
+
+func (run_control *RunControl) apply(client *rpc.Client, pid int) error {
+
+	if run_control.Rows != 0 || run_control.Cols != 0 {
+		resize := noguest.ResizeCommand{
+			Pid:  pid,
+			Rows: run_control.Rows,
+			Cols: run_control.Cols,
+		}
+		var resize_result noguest.ResizeResult
+		err := client.Call("Server.Resize", &resize, &resize_result)
+		if err != nil {
+			return err
+		}
+	}
+
+	if run_control.Signal != 0 {
+		kill := noguest.KillCommand{
+			Pid:    pid,
+			Signal: run_control.Signal,
+		}
+		var kill_result noguest.KillResult
+		err := client.Call("Server.Kill", &kill, &kill_result)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (control *Control) handle(conn_fd int) {
 
 	control_file := os.NewFile(uintptr(conn_fd), "control")
-	defer control_file.Close()
+	conn := NewFdConn(control_file)
+	defer conn.Close()
 
 	// Read single header.
 	// Our header is exactly 9 characters, and we
 	// expect the last character to be a newline.
 	// This is a simple plaintext protocol.
 	header_buf := make([]byte, 9, 9)
-	n, err := control_file.Read(header_buf)
+	n, err := conn.Read(header_buf)
 	if n != 9 || header_buf[8] != '\n' {
 		if err != nil {
 			control_file.Write([]byte(err.Error()))
@@ -117,6 +176,24 @@ func (control *Control) handle(
 		outputs := make(chan error)
 		exitcode := make(chan int)
 
+		// Open our streams.
+		output, err := control.OpenStream(protocol.StreamName(
+			protocol.StreamOutput,
+			strconv.Itoa(pid)))
+		if err != nil {
+			encoder.Encode(err.Error())
+			return
+		}
+		defer output.Close()
+		input, err := control.OpenStream(protocol.StreamName(
+			protocol.StreamInput,
+			strconv.Itoa(pid)))
+		if err != nil {
+			encoder.Encode(err.Error())
+			return
+		}
+		defer input.Close()
+
 		// This indicates we're okay.
 		encoder.Encode(nil)
 
@@ -135,19 +212,18 @@ func (control *Control) handle(
 		}()
 
 		// Read from stdout & stderr.
+		// Each chunk is sent as an object with
+		// the data and the stream it came from.
 		go func() {
-			read := noguest.ReadCommand{
-				Pid: pid,
-				N:   4096,
-			}
+			output_decoder := utils.NewDecoder(output)
 			var read_result noguest.ReadResult
 			for {
-				err := client.Call("Server.Read", &read, &read_result)
+				err := output_decoder.Decode(&read_result)
 				if err != nil {
 					inputs <- err
 					return
 				}
-				err = encoder.Encode(read_result.Data)
+				err = encoder.Encode(&read_result)
 				if err != nil {
 					inputs <- err
 					return
@@ -156,18 +232,37 @@ func (control *Control) handle(
 		}()
 
 		// Write to stdin.
+		// Each value is either a string (the data), or
+		// a RunControl object (signals, resizes and EOF).
 		go func() {
-			write := noguest.WriteCommand{
-				Pid: pid,
-			}
-			var write_result noguest.WriteResult
 			for {
-				err := decoder.Decode(&write.Data)
+				var value json.RawMessage
+				err := decoder.Decode(&value)
 				if err != nil {
 					outputs <- err
 					return
 				}
-				err = client.Call("Server.Write", &write, &write_result)
+				if len(value) > 0 && value[0] == '{' {
+					var run_control RunControl
+					err = json.Unmarshal(value, &run_control)
+					if err == nil && run_control.CloseStdin {
+						err = input.CloseWrite()
+					}
+					if err == nil {
+						err = run_control.apply(client, pid)
+					}
+					// Errors from the guest (i.e. the process
+					// has already exited) are not fatal here.
+					if _, ok := err.(rpc.ServerError); ok {
+						err = nil
+					}
+				} else {
+					var data []byte
+					err = json.Unmarshal(value, &data)
+					if err == nil {
+						_, err = input.Write(data)
+					}
+				}
 				if err != nil {
 					outputs <- err
 					return
@@ -176,6 +271,8 @@ func (control *Control) handle(
 		}()
 
 		// Wait till exit.
+		// If the process was killed by a signal,
+		// then this will be 128+signal (as per the shell).
 		status := <-exitcode
 		encoder.Encode(status)
 
@@ -188,22 +285,112 @@ func (control *Control) handle(
 	} else if header == "NOVM RPC\n" {
 
 		// Run as JSON RPC connection.
-		codec := jsonrpc.NewServerCodec(control_file)
+		// Each connection has its own server, so that
+		// the Rpc can see any descriptors passed on it.
+		server := rpc.NewServer()
+		server.Register(control.rpc.withConn(conn))
+		codec := jsonrpc.NewServerCodec(conn)
 		server.ServeCodec(codec)
+
+	} else if header == "NOVM EVT\n" {
+
+		// Stream all events.
+		control.streamEvents(control_file)
+
+	} else if header == "NOVM MET\n" {
+
+		// Dump metrics (Prometheus text format).
+		metrics := CollectMetrics(control.vcpus, control.rpc.model)
+		metrics.WritePrometheus(control_file)
+
+	} else if header == "NOVM CPY\n" {
+
+		// Copy files in or out as a tar archive.
+		control.streamCopy(control_file)
+
+	} else if header == "NOVM GDB\n" {
+
+		// Speak the gdb remote protocol.
+		err := control.debugger.Serve(control_file)
+		if err != nil {
+			log.Printf("Debugger: %s", err.Error())
+		}
 	}
 }
 
-func (control *Control) Serve() {
+func (control *Control) streamEvents(control_file *os.File) {
+
+	listener := control.events.Listen()
+	defer control.events.Unlisten(listener)
+
+	// Notice when the client goes away.
+	// We don't expect to read anything, but
+	// otherwise we would only notice on a write.
+	closed := make(chan bool, 1)
+	go func() {
+		buffer := make([]byte, 1, 1)
+		for {
+			_, err := control_file.Read(buffer)
+			if err != nil {
+				closed <- true
+				return
+			}
+		}
+	}()
+
+	encoder := utils.NewEncoder(control_file)
+	for {
+		select {
+		case event := <-listener:
+			err := encoder.Encode(&event)
+			if err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+func (control *Control) Events() *Events {
+	return control.events
+}
+
+func (control *Control) VcpuMetrics(id int) *VcpuMetrics {
+	return control.vcpus[id]
+}
+
+func (control *Control) Debugger() *Debugger {
+	return control.debugger
+}
+
+//
+// Migrated --
+//
+// A channel that is signalled once the guest has
+// been migrated successfully (see Rpc.Migrate).
+//
+func (control *Control) Migrated() <-chan bool {
+	return control.migrated
+}
+
+//
+// Shutdown --
+//
+// Gracefully power off the guest (see Rpc.Shutdown).
+//
+func (control *Control) Shutdown(timeout time.Duration) error {
+	_, err := control.rpc.shutdown(timeout)
+	return err
+}
 
-	// Bind our rpc server.
-	server := rpc.NewServer()
-	server.Register(control.rpc)
+func (control *Control) Serve() {
 
 	for {
 		// Accept clients.
 		nfd, _, err := syscall.Accept(control.control_fd)
 		if err == nil {
-			go control.handle(nfd, server)
+			go control.handle(nfd)
 		}
 	}
 }
@@ -227,16 +414,43 @@ func NewControl(
 	control.control_fd = control_fd
 	control.real_init = real_init
 	control.proxy = proxy
-	control.rpc = NewRpc(model, vm, tracer)
+	control.events = NewEvents()
+	control.vcpus = NewVcpuMetrics(vm)
+	control.debugger = NewDebugger(vm, model, control.events)
+	control.heartbeat = NewHeartbeat(control.events, vm)
+	control.migrated = make(chan bool, 1)
+	control.rpc = NewRpc(
+		model,
+		vm,
+		tracer,
+		control.events,
+		control.vcpus,
+		control.debugger,
+		control.Ready,
+		control.OpenStream,
+		control.heartbeat,
+		control.migrated)
+
+	// Report all device errors.
+	model.SetErrorHandler(func(device machine.Device, err error) {
+		control.events.Send(Event{
+			Type:   EventDeviceError,
+			Device: device.Name(),
+			Error:  err.Error()})
+	})
 
 	// Start our barrier.
-	control.client_res = make(chan error, 1)
+	control.agent = newGuestAgent(proxy)
+	go control.pump()
 	if is_load {
-		go control.init()
+		go control.init(control.agent)
 	} else {
 		// Already synchronized.
-		control.client_res <- nil
+		control.ready(control.agent, nil)
 	}
 
+	// Start checking on the guest.
+	go control.heartbeat.Run(control.Ready)
+
 	return control, nil
 }
@@ -0,0 +1,307 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"novmm/machine"
+	"novmm/platform"
+	"novmm/utils"
+	"os"
+	"syscall"
+)
+
+//
+// Snapshot --
+//
+// A snapshot file is laid out as follows:
+//
+//   * A fixed header (below).
+//   * The JSON-encoded State of the machine.
+//   * The user memory image (page aligned).
+//
+// The memory image is a copy of the user memory backing
+// file, so the layout in UserMemory.Allocated (included in
+// the state) applies directly. Pages which are all zeros
+// are not written, so the file will be sparse.
+//
+
+var SnapshotMagic = [8]byte{'N', 'O', 'V', 'M', 'S', 'N', 'A', 'P'}
+
+const SnapshotVersion = 1
+
+type snapshotHeader struct {
+	// Always SnapshotMagic.
+	Magic [8]byte
+
+	// Always SnapshotVersion.
+	Version uint64
+
+	// The encoded state.
+	StateOffset uint64
+	StateSize   uint64
+
+	// The memory image.
+	MemoryOffset uint64
+	MemorySize   uint64
+}
+
+func isZero(data []byte) bool {
+	for _, value := range data {
+		if value != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func writeMemory(
+	file *os.File,
+	user *machine.UserMemory,
+	base int64) error {
+
+	page := make([]byte, platform.PageSize, platform.PageSize)
+
+	for offset := uint64(0); offset < user.Size(); offset += platform.PageSize {
+		n, err := user.ReadAt(page, int64(offset))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if isZero(page[:n]) {
+			continue
+		}
+		_, err = file.WriteAt(page[:n], base+int64(offset))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func Snapshot(
+	vm *platform.Vm,
+	model *machine.Model,
+	path string) error {
+
+	user, err := findUserMemory(model)
+	if err != nil {
+		return err
+	}
+
+	// Stop everything.
+	// We need the memory image to match the state,
+	// so we hold the pause until both are written.
+	err = vm.Pause(false)
+	if err != nil {
+		return err
+	}
+	defer vm.Unpause(false)
+	err = model.Pause(false)
+	if err != nil {
+		return err
+	}
+	defer model.Unpause(false)
+
+	state, err := SaveState(vm, model, nil)
+	if err != nil {
+		return err
+	}
+	state_data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+
+	// Build our header.
+	header := snapshotHeader{
+		Magic:       SnapshotMagic,
+		Version:     SnapshotVersion,
+		StateOffset: uint64(binary.Size(&snapshotHeader{})),
+		StateSize:   uint64(len(state_data)),
+		MemorySize:  user.Size(),
+	}
+	header.MemoryOffset = platform.Align(
+		header.StateOffset+header.StateSize,
+		platform.PageSize,
+		true)
+
+	// Write everything out.
+	// We write to a temporary file first, so
+	// that we never leave a partial snapshot.
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	defer os.Remove(file.Name())
+
+	err = binary.Write(file, binary.LittleEndian, &header)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(state_data)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(int64(header.MemoryOffset + header.MemorySize))
+	if err != nil {
+		return err
+	}
+	err = writeMemory(file, user, int64(header.MemoryOffset))
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func (state *State) setMemory(fd int, offset int64) error {
+
+	for i, info := range state.Devices {
+		if info.Driver != "user-memory" {
+			continue
+		}
+		data, ok := deviceData(&state.Devices[i])
+		if !ok {
+			break
+		}
+		data["fd"] = json.Number(fmt.Sprintf("%d", fd))
+		data["offset"] = json.Number(fmt.Sprintf("%d", offset))
+		return nil
+	}
+
+	return machine.UserMemoryNotFound
+}
+
+func newMemory(size uint64) (int, error) {
+
+	// Create a new backing file.
+	// This is unlinked immediately, and we dup the
+	// descriptor as it must survive a restart() (the
+	// TempFile is opened CLOEXEC, see main.go).
+	memory_file, err := ioutil.TempFile(os.TempDir(), "memory")
+	if err != nil {
+		return -1, err
+	}
+	defer memory_file.Close()
+	err = os.Remove(memory_file.Name())
+	if err != nil {
+		return -1, err
+	}
+	err = memory_file.Truncate(int64(size))
+	if err != nil {
+		return -1, err
+	}
+
+	return syscall.Dup(int(memory_file.Fd()))
+}
+
+func LoadSnapshot(path string, local *State) (*State, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Check our header.
+	var header snapshotHeader
+	err = binary.Read(file, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Magic != SnapshotMagic ||
+		header.Version != SnapshotVersion {
+		return nil, SnapshotInvalid
+	}
+
+	// Read our state.
+	state := new(State)
+	decoder := utils.NewDecoder(io.NewSectionReader(
+		file,
+		int64(header.StateOffset),
+		int64(header.StateSize)))
+	err = decoder.Decode(state)
+	if err != nil {
+		return nil, err
+	}
+
+	// Figure out where memory goes.
+	// If we have local state, then we use those resources
+	// (as per migration). Otherwise, we create new memory.
+	var fd int
+	var base int64
+	is_new := local == nil
+	if !is_new {
+		fd, base, err = localMemory(local)
+		if err != nil {
+			return nil, err
+		}
+		err = state.mergeLocal(local)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Without local state, we can only create memory.
+		// Any other host resources (taps, disks) must be
+		// supplied by the caller, so we refuse to continue.
+		for i, info := range state.Devices {
+			if info.Driver == "user-memory" {
+				continue
+			}
+			data, ok := deviceData(&state.Devices[i])
+			if ok && hasHostFd(data) {
+				return nil, MissingHostResources(info.Name)
+			}
+		}
+		fd, err = newMemory(header.MemorySize)
+		if err != nil {
+			return nil, err
+		}
+		err = state.setMemory(fd, 0)
+		if err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+
+	// Copy in the memory image.
+	// New memory is already zero, so we can skip
+	// those pages (matching the snapshot itself).
+	page := make([]byte, platform.PageSize, platform.PageSize)
+	for offset := uint64(0); offset < header.MemorySize; offset += platform.PageSize {
+		n, err := file.ReadAt(page, int64(header.MemoryOffset+offset))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if is_new && isZero(page[:n]) {
+			continue
+		}
+		_, err = syscall.Pwrite(fd, page[:n], base+int64(offset))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
@@ -15,8 +15,10 @@
 package control
 
 import (
+	"io"
 	"novmm/machine"
 	"novmm/platform"
+	"novmm/utils"
 )
 
 //
@@ -40,7 +42,80 @@ type State struct {
 	Vcpus []platform.VcpuInfo `json:"vcpus,omitempty"`
 }
 
-func SaveState(vm *platform.Vm, model *machine.Model) (State, error) {
+//
+// WriteState --
+//
+// Encode the state for another novmm. This is used
+// both for a restart (via the statefd) and migration.
+//
+func WriteState(writer io.Writer, state *State) error {
+	encoder := utils.NewEncoder(writer)
+	return encoder.Encode(state)
+}
+
+//
+// ReadState --
+//
+// Decode the state written by WriteState.
+//
+func ReadState(reader io.Reader) (*State, error) {
+	decoder := utils.NewDecoder(reader)
+	state := new(State)
+	err := decoder.Decode(state)
+	return state, err
+}
+
+//
+// Freezer --
+//
+// Something that can quiesce the guest filesystems.
+//
+type Freezer interface {
+	Freeze() error
+	Thaw() error
+}
+
+//
+// statePlatform --
+//
+// The parts of the Vm used by saveState.
+//
+type statePlatform interface {
+	Pause(manual bool) error
+	Unpause(manual bool) error
+	VcpuInfo() ([]platform.VcpuInfo, error)
+}
+
+func SaveState(
+	vm *platform.Vm,
+	model *machine.Model,
+	freezer Freezer) (State, error) {
+
+	return saveState(
+		vm,
+		func() ([]machine.DeviceInfo, error) {
+			return model.DeviceInfo(vm)
+		},
+		freezer)
+}
+
+func saveState(
+	vm statePlatform,
+	device_info func() ([]machine.DeviceInfo, error),
+	freezer Freezer) (State, error) {
+
+	// Freeze the guest filesystems.
+	// This happens prior to the pause (the guest needs
+	// to be running to do it), and we thaw after resuming.
+	// NOTE: The deferred Unpause below runs first, so the
+	// order is always Freeze, Pause, Unpause and Thaw.
+	if freezer != nil {
+		err := freezer.Freeze()
+		if err != nil {
+			return State{}, err
+		}
+		defer freezer.Thaw()
+	}
 
 	// Pause the vm.
 	// NOTE: Our model will also be stopped automatically
@@ -63,7 +138,7 @@ func SaveState(vm *platform.Vm, model *machine.Model) (State, error) {
 	// NOTE: This should block until devices have
 	// actually quiesed (finished processing outstanding
 	// requests generated by the VCPUs).
-	devices, err := model.DeviceInfo(vm)
+	devices, err := device_info()
 	if err != nil {
 		return State{}, err
 	}
@@ -0,0 +1,100 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"errors"
+	"novmm/machine"
+	"novmm/platform"
+	"reflect"
+	"testing"
+)
+
+//
+// testSteps --
+//
+// Records the order of everything done by saveState.
+// It stands in for both the Vm and the Freezer.
+//
+type testSteps struct {
+	steps []string
+
+	// Errors to return (by step).
+	errors map[string]error
+}
+
+func (test *testSteps) step(name string) error {
+	test.steps = append(test.steps, name)
+	return test.errors[name]
+}
+
+func (test *testSteps) Pause(manual bool) error {
+	return test.step("pause")
+}
+
+func (test *testSteps) Unpause(manual bool) error {
+	return test.step("unpause")
+}
+
+func (test *testSteps) VcpuInfo() ([]platform.VcpuInfo, error) {
+	return nil, test.step("vcpus")
+}
+
+func (test *testSteps) Freeze() error {
+	return test.step("freeze")
+}
+
+func (test *testSteps) Thaw() error {
+	return test.step("thaw")
+}
+
+func (test *testSteps) DeviceInfo() ([]machine.DeviceInfo, error) {
+	return nil, test.step("devices")
+}
+
+func checkSteps(t *testing.T, errs map[string]error, expected []string) {
+
+	test := &testSteps{errors: errs}
+	_, err := saveState(test, test.DeviceInfo, test)
+	if len(errs) == 0 && err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	} else if len(errs) > 0 && err == nil {
+		t.Errorf("expected an error")
+	}
+	if !reflect.DeepEqual(test.steps, expected) {
+		t.Errorf("got %v, expected %v", test.steps, expected)
+	}
+}
+
+func TestSaveStateFreeze(t *testing.T) {
+
+	failed := errors.New("failed")
+
+	// The usual case.
+	checkSteps(t, nil, []string{
+		"freeze", "pause", "vcpus", "devices", "unpause", "thaw"})
+
+	// We always thaw if anything fails.
+	checkSteps(t, map[string]error{"devices": failed}, []string{
+		"freeze", "pause", "vcpus", "devices", "unpause", "thaw"})
+	checkSteps(t, map[string]error{"vcpus": failed}, []string{
+		"freeze", "pause", "vcpus", "unpause", "thaw"})
+	checkSteps(t, map[string]error{"pause": failed}, []string{
+		"freeze", "pause", "thaw"})
+
+	// But there's nothing to thaw if the freeze fails.
+	checkSteps(t, map[string]error{"freeze": failed}, []string{
+		"freeze"})
+}
@@ -20,3 +20,4 @@ import (
 
 var ExitWithoutReason = errors.New("Exit without reason?")
 var NoVcpus = errors.New("No vcpus?")
+var NoKernel = errors.New("No kernel to reset with?")
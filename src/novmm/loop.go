@@ -16,6 +16,7 @@ package main
 
 import (
 	"log"
+	"novmm/control"
 	"novmm/loader"
 	"novmm/machine"
 	"novmm/platform"
@@ -26,7 +27,10 @@ func Loop(
 	vm *platform.Vm,
 	vcpu *platform.Vcpu,
 	model *machine.Model,
-	tracer *loader.Tracer) error {
+	tracer *loader.Tracer,
+	metrics *control.VcpuMetrics,
+	debugger *control.Debugger,
+	resetter *Resetter) error {
 
 	// It's not really kosher to switch threads constantly when running a
 	// KVM VCPU. So we simply lock this goroutine to a single system
@@ -51,6 +55,9 @@ func Loop(
 			return ExitWithoutReason
 		}
 
+		// Count the exit.
+		metrics.Exit(err)
+
 		// Handle the error.
 		switch err.(type) {
 		case *platform.ExitPio:
@@ -60,11 +67,17 @@ func Loop(
 			err = model.HandleMmio(vm, err.(*platform.ExitMmio))
 
 		case *platform.ExitDebug:
-			err = nil
+			err = debugger.Exit(vcpu, err.(*platform.ExitDebug))
 
 		case *platform.ExitShutdown:
-			// Vcpu shutdown.
-			return nil
+			// A triple fault resets the machine.
+			// (This is how many guests will reboot.)
+			err = resetter.Request(vcpu)
+		}
+
+		// Did the guest ask for a reset?
+		if err == machine.SystemReset {
+			err = resetter.Request(vcpu)
 		}
 
 		// Error handling the exit.
@@ -19,6 +19,7 @@
  */
 
 #include "acpi.h"
+#include <stdio.h>
 #include <string.h>
 
 static inline __u8 checksum(
@@ -84,11 +85,13 @@ typedef struct acpi_header {
 typedef struct rsdt {
     acpi_header_t header;
     __u32 madt_address;
+    __u32 fadt_address;
 } __attribute__((packed)) rsdt_t;
 
 long build_rsdt(
     void* start,
-    __u32 madt_address)
+    __u32 madt_address,
+    __u32 fadt_address)
 {
     rsdt_t* rsdt = (rsdt_t*)start;
 
@@ -102,6 +105,7 @@ long build_rsdt(
     rsdt->header.asl_compiler_rev = 0;
 
     rsdt->madt_address = madt_address;
+    rsdt->fadt_address = fadt_address;
     rsdt->header.checksum = checksum(start, rsdt->header.length);
 
     return rsdt->header.length;
@@ -110,11 +114,13 @@ long build_rsdt(
 typedef struct xsdt {
     acpi_header_t header;
     __u64 madt_address;
+    __u64 fadt_address;
 } __attribute__((packed)) xsdt_t;
 
 long build_xsdt(
     void* start,
-    __u64 madt_address)
+    __u64 madt_address,
+    __u64 fadt_address)
 {
     xsdt_t* xsdt = (xsdt_t*)start;
 
@@ -128,6 +134,7 @@ long build_xsdt(
     xsdt->header.asl_compiler_rev = 0;
 
     xsdt->madt_address = madt_address;
+    xsdt->fadt_address = fadt_address;
     xsdt->header.checksum = checksum(start, xsdt->header.length);
 
     return xsdt->header.length;
@@ -187,14 +194,348 @@ long build_madt_device_ioapic(
     return ioapic->device.length;
 }
 
+typedef struct madt_device_iso {
+    madt_device_t device;
+    __u8 bus;
+    __u8 source;
+    __u32 interrupt;
+    __u16 flags;
+} __attribute__((packed)) madt_device_iso_t;
+
+long build_madt_device_iso(
+    void* start,
+    __u8 source,
+    __u32 interrupt,
+    __u16 flags) {
+
+    madt_device_iso_t* iso = (madt_device_iso_t*)start;
+
+    iso->device.type = 2;
+    iso->device.length = sizeof(madt_device_iso_t);
+    iso->bus = 0; /* ISA. */
+    iso->source = source;
+    iso->interrupt = interrupt;
+    iso->flags = flags;
+
+    return iso->device.length;
+}
+
 typedef struct dsdt {
     acpi_header_t header;
-} dsdt_t;
+    __u8 aml[0];
+} __attribute__((packed)) dsdt_t;
+
+/*
+ * A minimal AML encoder.
+ *
+ * Packages are encoded with a length prefix that includes
+ * itself, so we write the contents first and then shift
+ * them over once we know how many bytes the length takes.
+ */
+
+typedef struct aml {
+    __u8* data;
+    long offset;
+} aml_t;
+
+static void aml_byte(aml_t* aml, __u8 value) {
+    aml->data[aml->offset++] = value;
+}
+
+static void aml_bytes(aml_t* aml, const char* value, int length) {
+    memcpy(&aml->data[aml->offset], value, length);
+    aml->offset += length;
+}
+
+static void aml_name(aml_t* aml, const char* name) {
+    aml_bytes(aml, name, strlen(name));
+}
+
+static void aml_int(aml_t* aml, __u32 value) {
+    if( value == 0 ) {
+        aml_byte(aml, 0x00); /* ZeroOp. */
+    } else if( value == 1 ) {
+        aml_byte(aml, 0x01); /* OneOp. */
+    } else if( value <= 0xff ) {
+        aml_byte(aml, 0x0a); /* BytePrefix. */
+        aml_byte(aml, value);
+    } else if( value <= 0xffff ) {
+        aml_byte(aml, 0x0b); /* WordPrefix. */
+        aml_byte(aml, value);
+        aml_byte(aml, value >> 8);
+    } else {
+        aml_byte(aml, 0x0c); /* DWordPrefix. */
+        aml_byte(aml, value);
+        aml_byte(aml, value >> 8);
+        aml_byte(aml, value >> 16);
+        aml_byte(aml, value >> 24);
+    }
+}
+
+static long aml_pkg_begin(aml_t* aml) {
+    return aml->offset;
+}
+
+static void aml_pkg_end(aml_t* aml, long start) {
+    long length = aml->offset - start;
+    int extra = 0;
+
+    /* Figure out how many bytes the length needs. */
+    if( length + 1 < 0x40 ) {
+        extra = 0;
+    } else if( length + 2 < 0x1000 ) {
+        extra = 1;
+    } else {
+        extra = 2;
+    }
+    length += extra + 1;
+
+    memmove(&aml->data[start+extra+1], &aml->data[start], aml->offset-start);
+    aml->offset += extra + 1;
+
+    if( extra == 0 ) {
+        aml->data[start] = length;
+    } else {
+        aml->data[start] = (extra << 6) | (length & 0xf);
+        aml->data[start+1] = length >> 4;
+        if( extra == 2 ) {
+            aml->data[start+2] = length >> 12;
+        }
+    }
+}
 
 long build_dsdt(
-    void* start) {
+    void* start,
+    __u8 s5_type,
+    __u16 hotplug_address,
+    __u8 hotplug_gpe,
+    int slots) {
 
     dsdt_t* dsdt = (dsdt_t*)start;
+    aml_t aml = { dsdt->aml, 0 };
+    char name[5];
+    long scope, device, method, cond;
+    int slot;
+
+    /*
+     * Name (_S5, Package (0x04) { s5_type, s5_type, Zero, Zero })
+     *
+     * The kernel will look this up before writing
+     * SLP_TYP|SLP_EN to PM1 control, which is how
+     * we learn about the power off.
+     */
+    __u8 s5[] = {
+        0x08, '_', 'S', '5', '_',
+        0x12, 0x08, 0x04,
+        0x0a, s5_type,
+        0x0a, s5_type,
+        0x00,
+        0x00,
+    };
+    aml_bytes(&aml, (const char*)s5, sizeof(s5));
+
+    /*
+     * Scope (\_SB) {
+     *     Device (PCI0) {
+     *         Name (_HID, EisaId ("PNP0A03"))
+     *         Name (_ADR, Zero)
+     *         OperationRegion (PCHP, SystemIO, hotplug_address, 0x10)
+     *         Field (PCHP, DWordAcc, NoLock, Preserve) {
+     *             PCIU, 32, PCID, 32, B0EJ, 32, PCIP, 32
+     *         }
+     *         Method (PCEJ, 1) { Store (ShiftLeft (One, Arg0), B0EJ) }
+     *         Method (PCST, 1) {
+     *             If (And (PCIP, ShiftLeft (One, Arg0))) { Return (0x0F) }
+     *             Return (Zero)
+     *         }
+     *         Device (Sxx) {
+     *             Name (_ADR, xx << 16)
+     *             Name (_SUN, xx)
+     *             Method (_EJ0, 1) { PCEJ (xx) }
+     *             Method (_STA) { Return (PCST (xx)) }
+     *         }
+     *         ...
+     *         Method (PCNT) {
+     *             Store (PCIU, Local0)
+     *             Store (PCID, Local1)
+     *             If (And (Local0, 1 << xx)) { Notify (Sxx, One) }
+     *             If (And (Local1, 1 << xx)) { Notify (Sxx, 0x03) }
+     *             ...
+     *         }
+     *     }
+     * }
+     *
+     * The PCIU (up) and PCID (down) registers hold the slots
+     * which should be checked or ejected, and are cleared when
+     * read. PCIP holds the slots which are currently present,
+     * and a slot is ejected by writing its bit to B0EJ.
+     */
+    aml_byte(&aml, 0x10); /* ScopeOp. */
+    scope = aml_pkg_begin(&aml);
+    aml_name(&aml, "\\_SB_");
+
+    aml_byte(&aml, 0x5b); /* DeviceOp. */
+    aml_byte(&aml, 0x82);
+    device = aml_pkg_begin(&aml);
+    aml_name(&aml, "PCI0");
+
+    aml_byte(&aml, 0x08); /* NameOp. */
+    aml_name(&aml, "_HID");
+    aml_int(&aml, 0x030ad041); /* EisaId ("PNP0A03"). */
+    aml_byte(&aml, 0x08); /* NameOp. */
+    aml_name(&aml, "_ADR");
+    aml_int(&aml, 0);
+
+    aml_byte(&aml, 0x5b); /* OpRegionOp. */
+    aml_byte(&aml, 0x80);
+    aml_name(&aml, "PCHP");
+    aml_byte(&aml, 0x01); /* SystemIO. */
+    aml_int(&aml, hotplug_address);
+    aml_int(&aml, 0x10);
+
+    aml_byte(&aml, 0x5b); /* FieldOp. */
+    aml_byte(&aml, 0x81);
+    method = aml_pkg_begin(&aml);
+    aml_name(&aml, "PCHP");
+    aml_byte(&aml, 0x03); /* DWordAcc, NoLock, Preserve. */
+    aml_name(&aml, "PCIU");
+    aml_byte(&aml, 32);
+    aml_name(&aml, "PCID");
+    aml_byte(&aml, 32);
+    aml_name(&aml, "B0EJ");
+    aml_byte(&aml, 32);
+    aml_name(&aml, "PCIP");
+    aml_byte(&aml, 32);
+    aml_pkg_end(&aml, method);
+
+    aml_byte(&aml, 0x14); /* MethodOp. */
+    method = aml_pkg_begin(&aml);
+    aml_name(&aml, "PCEJ");
+    aml_byte(&aml, 0x01); /* One argument. */
+    aml_byte(&aml, 0x70); /* StoreOp. */
+    aml_byte(&aml, 0x79); /* ShiftLeftOp. */
+    aml_byte(&aml, 0x01); /* One. */
+    aml_byte(&aml, 0x68); /* Arg0. */
+    aml_byte(&aml, 0x00); /* No target. */
+    aml_name(&aml, "B0EJ");
+    aml_pkg_end(&aml, method);
+
+    aml_byte(&aml, 0x14); /* MethodOp. */
+    method = aml_pkg_begin(&aml);
+    aml_name(&aml, "PCST");
+    aml_byte(&aml, 0x01); /* One argument. */
+    aml_byte(&aml, 0xa0); /* IfOp. */
+    cond = aml_pkg_begin(&aml);
+    aml_byte(&aml, 0x7b); /* AndOp. */
+    aml_name(&aml, "PCIP");
+    aml_byte(&aml, 0x79); /* ShiftLeftOp. */
+    aml_byte(&aml, 0x01); /* One. */
+    aml_byte(&aml, 0x68); /* Arg0. */
+    aml_byte(&aml, 0x00); /* No target. */
+    aml_byte(&aml, 0x00); /* No target. */
+    aml_byte(&aml, 0xa4); /* ReturnOp. */
+    aml_int(&aml, 0x0f);
+    aml_pkg_end(&aml, cond);
+    aml_byte(&aml, 0xa4); /* ReturnOp. */
+    aml_int(&aml, 0);
+    aml_pkg_end(&aml, method);
+
+    for( slot = 0; slot < slots; slot += 1 ) {
+        snprintf(name, sizeof(name), "S%02X_", slot);
+
+        aml_byte(&aml, 0x5b); /* DeviceOp. */
+        aml_byte(&aml, 0x82);
+        method = aml_pkg_begin(&aml);
+        aml_name(&aml, name);
+
+        aml_byte(&aml, 0x08); /* NameOp. */
+        aml_name(&aml, "_ADR");
+        aml_int(&aml, slot << 16);
+        aml_byte(&aml, 0x08); /* NameOp. */
+        aml_name(&aml, "_SUN");
+        aml_int(&aml, slot);
+
+        aml_byte(&aml, 0x14); /* MethodOp. */
+        cond = aml_pkg_begin(&aml);
+        aml_name(&aml, "_EJ0");
+        aml_byte(&aml, 0x01); /* One argument. */
+        aml_name(&aml, "PCEJ");
+        aml_int(&aml, slot);
+        aml_pkg_end(&aml, cond);
+
+        aml_byte(&aml, 0x14); /* MethodOp. */
+        cond = aml_pkg_begin(&aml);
+        aml_name(&aml, "_STA");
+        aml_byte(&aml, 0x00); /* No arguments. */
+        aml_byte(&aml, 0xa4); /* ReturnOp. */
+        aml_name(&aml, "PCST");
+        aml_int(&aml, slot);
+        aml_pkg_end(&aml, cond);
+
+        aml_pkg_end(&aml, method);
+    }
+
+    aml_byte(&aml, 0x14); /* MethodOp. */
+    method = aml_pkg_begin(&aml);
+    aml_name(&aml, "PCNT");
+    aml_byte(&aml, 0x00); /* No arguments. */
+    aml_byte(&aml, 0x70); /* StoreOp. */
+    aml_name(&aml, "PCIU");
+    aml_byte(&aml, 0x60); /* Local0. */
+    aml_byte(&aml, 0x70); /* StoreOp. */
+    aml_name(&aml, "PCID");
+    aml_byte(&aml, 0x61); /* Local1. */
+    for( slot = 0; slot < slots; slot += 1 ) {
+        snprintf(name, sizeof(name), "S%02X_", slot);
+
+        /* Device check. */
+        aml_byte(&aml, 0xa0); /* IfOp. */
+        cond = aml_pkg_begin(&aml);
+        aml_byte(&aml, 0x7b); /* AndOp. */
+        aml_byte(&aml, 0x60); /* Local0. */
+        aml_int(&aml, 1U << slot);
+        aml_byte(&aml, 0x00); /* No target. */
+        aml_byte(&aml, 0x86); /* NotifyOp. */
+        aml_name(&aml, name);
+        aml_int(&aml, 1);
+        aml_pkg_end(&aml, cond);
+
+        /* Eject request. */
+        aml_byte(&aml, 0xa0); /* IfOp. */
+        cond = aml_pkg_begin(&aml);
+        aml_byte(&aml, 0x7b); /* AndOp. */
+        aml_byte(&aml, 0x61); /* Local1. */
+        aml_int(&aml, 1U << slot);
+        aml_byte(&aml, 0x00); /* No target. */
+        aml_byte(&aml, 0x86); /* NotifyOp. */
+        aml_name(&aml, name);
+        aml_int(&aml, 3);
+        aml_pkg_end(&aml, cond);
+    }
+    aml_pkg_end(&aml, method);
+
+    aml_pkg_end(&aml, device);
+    aml_pkg_end(&aml, scope);
+
+    /*
+     * Scope (\_GPE) {
+     *     Method (_Exx) { \_SB.PCI0.PCNT () }
+     * }
+     */
+    aml_byte(&aml, 0x10); /* ScopeOp. */
+    scope = aml_pkg_begin(&aml);
+    aml_name(&aml, "\\_GPE");
+    aml_byte(&aml, 0x14); /* MethodOp. */
+    method = aml_pkg_begin(&aml);
+    snprintf(name, sizeof(name), "_E%02X", hotplug_gpe);
+    aml_name(&aml, name);
+    aml_byte(&aml, 0x00); /* No arguments. */
+    aml_byte(&aml, 0x5c); /* RootChar. */
+    aml_byte(&aml, 0x2f); /* MultiNamePrefix. */
+    aml_byte(&aml, 0x03);
+    aml_name(&aml, "_SB_PCI0PCNT");
+    aml_pkg_end(&aml, method);
+    aml_pkg_end(&aml, scope);
 
     memcpy(dsdt->header.signature, "DSDT", 4);
     dsdt->header.revision = 1;
@@ -204,11 +545,136 @@ long build_dsdt(
     memcpy(dsdt->header.asl_compiler_id, "NOVM", 4);
     dsdt->header.asl_compiler_rev = 0;
 
-    dsdt->header.length = sizeof(dsdt_t);
+    dsdt->header.length = sizeof(dsdt_t) + aml.offset;
     dsdt->header.checksum = checksum(start, dsdt->header.length);
     return dsdt->header.length;
 }
 
+typedef struct gas {
+    __u8 space_id;
+    __u8 bit_width;
+    __u8 bit_offset;
+    __u8 access_width;
+    __u64 address;
+} __attribute__((packed)) gas_t;
+
+typedef struct fadt {
+    acpi_header_t header;
+    __u32 firmware_ctrl;
+    __u32 dsdt_address;
+    __u8 reserved0;
+    __u8 preferred_pm_profile;
+    __u16 sci_interrupt;
+    __u32 smi_cmd;
+    __u8 acpi_enable;
+    __u8 acpi_disable;
+    __u8 s4bios_req;
+    __u8 pstate_cnt;
+    __u32 pm1a_evt_blk;
+    __u32 pm1b_evt_blk;
+    __u32 pm1a_cnt_blk;
+    __u32 pm1b_cnt_blk;
+    __u32 pm2_cnt_blk;
+    __u32 pm_tmr_blk;
+    __u32 gpe0_blk;
+    __u32 gpe1_blk;
+    __u8 pm1_evt_len;
+    __u8 pm1_cnt_len;
+    __u8 pm2_cnt_len;
+    __u8 pm_tmr_len;
+    __u8 gpe0_blk_len;
+    __u8 gpe1_blk_len;
+    __u8 gpe1_base;
+    __u8 cst_cnt;
+    __u16 p_lvl2_lat;
+    __u16 p_lvl3_lat;
+    __u16 flush_size;
+    __u16 flush_stride;
+    __u8 duty_offset;
+    __u8 duty_width;
+    __u8 day_alrm;
+    __u8 mon_alrm;
+    __u8 century;
+    __u16 iapc_boot_arch;
+    __u8 reserved1;
+    __u32 flags;
+    gas_t reset_reg;
+    __u8 reset_value;
+    __u8 reserved2[3];
+    __u64 x_firmware_ctrl;
+    __u64 x_dsdt_address;
+    gas_t x_pm1a_evt_blk;
+    gas_t x_pm1b_evt_blk;
+    gas_t x_pm1a_cnt_blk;
+    gas_t x_pm1b_cnt_blk;
+    gas_t x_pm2_cnt_blk;
+    gas_t x_pm_tmr_blk;
+    gas_t x_gpe0_blk;
+    gas_t x_gpe1_blk;
+} __attribute__((packed)) fadt_t;
+
+long build_fadt(
+    void* start,
+    __u32 dsdt_address,
+    __u16 sci_interrupt,
+    __u32 pm1_evt_address,
+    __u32 pm1_cnt_address,
+    __u32 reset_address,
+    __u8 reset_value,
+    __u32 gpe0_address,
+    __u8 gpe0_len) {
+
+    fadt_t* fadt = (fadt_t*)start;
+    memset(fadt, 0, sizeof(fadt_t));
+
+    /* NOTE: The signature for the FADT is historical. */
+    memcpy(fadt->header.signature, "FACP", 4);
+    fadt->header.revision = 3;
+    memcpy(fadt->header.oem_id, "PERVIR", 6);
+    memcpy(fadt->header.oem_table_id, "FADT", 4);
+    fadt->header.oem_revision = 0;
+    memcpy(fadt->header.asl_compiler_id, "NOVM", 4);
+    fadt->header.asl_compiler_rev = 0;
+
+    fadt->dsdt_address = dsdt_address;
+    fadt->sci_interrupt = sci_interrupt;
+
+    /*
+     * We have no SMI command port. The guest will
+     * see SCI_EN already set in PM1 control, and
+     * assume that we are always in ACPI mode.
+     */
+    fadt->smi_cmd = 0;
+
+    fadt->pm1a_evt_blk = pm1_evt_address;
+    fadt->pm1_evt_len = 4;
+    fadt->pm1a_cnt_blk = pm1_cnt_address;
+    fadt->pm1_cnt_len = 2;
+
+    /* Our GPE block (status, then enable). */
+    fadt->gpe0_blk = gpe0_address;
+    fadt->gpe0_blk_len = gpe0_len;
+
+    /* Our reset register is a single I/O port. */
+    fadt->reset_reg.space_id = 1;
+    fadt->reset_reg.bit_width = 8;
+    fadt->reset_reg.address = reset_address;
+    fadt->reset_value = reset_value;
+
+    /*
+     * WBINVD is supported (bit 0), there is no
+     * fixed-feature sleep button (bit 5) and we have
+     * a reset register (bit 10). Note that bit 4 is
+     * clear, so the power button is a fixed feature
+     * driven by the PM1 event registers.
+     */
+    fadt->flags = (1 << 0) | (1 << 5) | (1 << 10);
+
+    fadt->header.length = sizeof(fadt_t);
+    fadt->header.checksum = checksum(start, fadt->header.length);
+    return fadt->header.length;
+}
+
 typedef struct madt {
     acpi_header_t header;
     __u32 lapic_address;
@@ -221,7 +687,8 @@ long build_madt(
     __u32 lapic_address,
     int vcpus,
     __u32 ioapic_address,
-    __u32 ioapic_interrupt) {
+    __u32 ioapic_interrupt,
+    __u8 sci_interrupt) {
 
     long offset = 0;
     int vcpu = 0;
@@ -248,6 +715,16 @@ long build_madt(
         (void*)((char*)&madt->devices[0] + offset),
         0, ioapic_address, ioapic_interrupt);
 
+    /*
+     * Build our SCI override.
+     * The SCI defaults to active low, but we drive
+     * the line directly so declare it active high
+     * (bits 0-1) and level triggered (bits 2-3).
+     */
+    offset += build_madt_device_iso(
+        (void*)((char*)&madt->devices[0] + offset),
+        sci_interrupt, sci_interrupt, 0x1 | (0x3 << 2));
+
     madt->header.length = sizeof(madt_t) + offset;
     madt->header.checksum = checksum(start, madt->header.length);
     return madt->header.length;
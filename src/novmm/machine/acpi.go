@@ -21,29 +21,469 @@ import "C"
 
 import (
 	"novmm/platform"
+	"sync"
 	"unsafe"
 )
 
+const (
+	AcpiPmBase       = 0x600
+	AcpiSciInterrupt = 9
+	AcpiS5Type       = 5
+	AcpiResetValue   = 0x1
+	AcpiDataSize     = 2 * platform.PageSize
+)
+
+const (
+	AcpiPm1StatusPWRBTN = 0x0100
+	AcpiPm1StatusWAK    = 0x8000
+)
+
+const (
+	AcpiPm1EnablePWRBTN = 0x0100
+)
+
+const (
+	AcpiGpe0Offset    = 0x8
+	AcpiGpe0Length    = 4
+	AcpiHotplugOffset = 0x10
+	AcpiHotplugGpe    = 1
+)
+
+const (
+	AcpiPm1ControlSCIEN  = 0x0001
+	AcpiPm1ControlSLPTYP = 0x1c00
+	AcpiPm1ControlSLPEN  = 0x2000
+)
+
+//
+// Acpi --
+//
+// Our ACPI tables, along with the fixed hardware
+// power management registers. We implement only
+// the PM1 event and control blocks, which is
+// enough for a power button and a soft-off (S5),
+// and a reset register.
+//
+// We also have a single GPE block, which is used
+// to signal PCI hot-plug events. The PCI root bridge
+// is described in the DSDT with a device for each
+// slot, and the guest learns which slots to check
+// or eject via our hot-plug registers (see acpi.c).
+//
+
 type Acpi struct {
-	BaseDevice
+	PioDevice
 
 	Addr platform.Paddr `json:"address"`
 	Data []byte         `json:"data"`
+
+	// Registers.
+	Pm1Status  uint16 `json:"pm1-status"`
+	Pm1Enable  uint16 `json:"pm1-enable"`
+	Pm1Control uint16 `json:"pm1-control"`
+	GpeStatus  uint16 `json:"gpe-status"`
+	GpeEnable  uint16 `json:"gpe-enable"`
+
+	// Hot-plug registers.
+	// These are bitmaps of PCI slots.
+	PciUp      uint32 `json:"pci-up"`
+	PciDown    uint32 `json:"pci-down"`
+	PciEjected uint32 `json:"pci-ejected"`
+
+	// Our vm (for the SCI).
+	vm *platform.Vm
+
+	// Our model (for the PCI bus).
+	model *Model
+
+	// Closed when the guest powers off.
+	off    chan bool
+	is_off bool
+
+	// Protects our registers.
+	lock sync.Mutex
+}
+
+type AcpiPm1Status struct {
+	*Acpi
+}
+
+type AcpiPm1Enable struct {
+	*Acpi
+}
+
+type AcpiPm1Control struct {
+	*Acpi
+}
+
+type AcpiReset struct {
+	*Acpi
+}
+
+type AcpiGpeStatus struct {
+	*Acpi
+}
+
+type AcpiGpeEnable struct {
+	*Acpi
+}
+
+type AcpiPciUp struct {
+	*Acpi
+}
+
+type AcpiPciDown struct {
+	*Acpi
+}
+
+type AcpiPciEject struct {
+	*Acpi
+}
+
+type AcpiPciPresent struct {
+	*Acpi
 }
 
 func NewAcpi(info *DeviceInfo) (Device, error) {
 	acpi := new(Acpi)
 	acpi.Addr = platform.Paddr(0xf0000)
+	acpi.off = make(chan bool)
+
+	// We are always in ACPI mode.
+	acpi.Pm1Control = AcpiPm1ControlSCIEN
+
+	acpi.PioDevice.Offset = AcpiPmBase
+	acpi.PioDevice.IoMap = IoMap{
+		MemoryRegion{0, 2}: &AcpiPm1Status{Acpi: acpi},
+		MemoryRegion{2, 2}: &AcpiPm1Enable{Acpi: acpi},
+		MemoryRegion{4, 2}: &AcpiPm1Control{Acpi: acpi},
+		MemoryRegion{6, 1}: &AcpiReset{Acpi: acpi},
+
+		MemoryRegion{AcpiGpe0Offset, 2}:   &AcpiGpeStatus{Acpi: acpi},
+		MemoryRegion{AcpiGpe0Offset+2, 2}: &AcpiGpeEnable{Acpi: acpi},
+
+		MemoryRegion{AcpiHotplugOffset, 4}:    &AcpiPciUp{Acpi: acpi},
+		MemoryRegion{AcpiHotplugOffset+4, 4}:  &AcpiPciDown{Acpi: acpi},
+		MemoryRegion{AcpiHotplugOffset+8, 4}:  &AcpiPciEject{Acpi: acpi},
+		MemoryRegion{AcpiHotplugOffset+12, 4}: &AcpiPciPresent{Acpi: acpi},
+	}
+
 	return acpi, acpi.init(info)
 }
 
+func (acpi *Acpi) updateSci() error {
+	// The SCI is level-triggered, and is asserted
+	// as long as there is an enabled status bit set.
+	if acpi.vm == nil {
+		return nil
+	}
+	return acpi.vm.Interrupt(
+		platform.Irq(AcpiSciInterrupt),
+		acpi.Pm1Status&acpi.Pm1Enable != 0 ||
+			acpi.GpeStatus&acpi.GpeEnable != 0)
+}
+
+func (reg *AcpiPm1Status) Read(offset uint64, size uint) (uint64, error) {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	return uint64(reg.Acpi.Pm1Status) >> (8 * offset), nil
+}
+
+func (reg *AcpiPm1Status) Write(offset uint64, size uint, value uint64) error {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	// Status bits are cleared by writing a one.
+	reg.Acpi.Pm1Status &= ^uint16(value << (8 * offset))
+	return reg.Acpi.updateSci()
+}
+
+func (reg *AcpiPm1Enable) Read(offset uint64, size uint) (uint64, error) {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	return uint64(reg.Acpi.Pm1Enable) >> (8 * offset), nil
+}
+
+func (reg *AcpiPm1Enable) Write(offset uint64, size uint, value uint64) error {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	mask := uint16(0xffff << (8 * offset))
+	reg.Acpi.Pm1Enable = (reg.Acpi.Pm1Enable & ^mask) | (uint16(value<<(8*offset)) & mask)
+	return reg.Acpi.updateSci()
+}
+
+func (reg *AcpiPm1Control) Read(offset uint64, size uint) (uint64, error) {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	return uint64(reg.Acpi.Pm1Control) >> (8 * offset), nil
+}
+
+func (reg *AcpiPm1Control) Write(offset uint64, size uint, value uint64) error {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	mask := uint16(0xffff << (8 * offset))
+	val := (reg.Acpi.Pm1Control & ^mask) | (uint16(value<<(8*offset)) & mask)
+
+	// SCI_EN is fixed, and SLP_EN always reads as zero.
+	reg.Acpi.Pm1Control = (val & ^uint16(AcpiPm1ControlSLPEN)) | AcpiPm1ControlSCIEN
+
+	if val&AcpiPm1ControlSLPEN != 0 {
+		slp_typ := (val & AcpiPm1ControlSLPTYP) >> 10
+		reg.Acpi.Debug("sleep type %d", slp_typ)
+		if slp_typ == AcpiS5Type {
+			reg.Acpi.powerOff()
+		}
+	}
+
+	return nil
+}
+
+func (reg *AcpiReset) Read(offset uint64, size uint) (uint64, error) {
+	return 0, nil
+}
+
+func (reg *AcpiReset) Write(offset uint64, size uint, value uint64) error {
+	if value == AcpiResetValue {
+		reg.Acpi.Debug("reset")
+		return SystemReset
+	}
+	return nil
+}
+
+func (reg *AcpiGpeStatus) Read(offset uint64, size uint) (uint64, error) {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	return uint64(reg.Acpi.GpeStatus) >> (8 * offset), nil
+}
+
+func (reg *AcpiGpeStatus) Write(offset uint64, size uint, value uint64) error {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	// Status bits are cleared by writing a one.
+	reg.Acpi.GpeStatus &= ^uint16(value << (8 * offset))
+	return reg.Acpi.updateSci()
+}
+
+func (reg *AcpiGpeEnable) Read(offset uint64, size uint) (uint64, error) {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	return uint64(reg.Acpi.GpeEnable) >> (8 * offset), nil
+}
+
+func (reg *AcpiGpeEnable) Write(offset uint64, size uint, value uint64) error {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	mask := uint16(0xffff << (8 * offset))
+	reg.Acpi.GpeEnable = (reg.Acpi.GpeEnable & ^mask) | (uint16(value<<(8*offset)) & mask)
+	return reg.Acpi.updateSci()
+}
+
+func (reg *AcpiPciUp) Read(offset uint64, size uint) (uint64, error) {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	// Cleared on read (see PCNT).
+	value := reg.Acpi.PciUp
+	reg.Acpi.PciUp = 0
+	return uint64(value) >> (8 * offset), nil
+}
+
+func (reg *AcpiPciUp) Write(offset uint64, size uint, value uint64) error {
+	return nil
+}
+
+func (reg *AcpiPciDown) Read(offset uint64, size uint) (uint64, error) {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	// Cleared on read (see PCNT).
+	value := reg.Acpi.PciDown
+	reg.Acpi.PciDown = 0
+	return uint64(value) >> (8 * offset), nil
+}
+
+func (reg *AcpiPciDown) Write(offset uint64, size uint, value uint64) error {
+	return nil
+}
+
+func (reg *AcpiPciEject) Read(offset uint64, size uint) (uint64, error) {
+	return 0, nil
+}
+
+func (reg *AcpiPciEject) Write(offset uint64, size uint, value uint64) error {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	// The guest has let go of these slots.
+	reg.Acpi.Debug("eject %x", value<<(8*offset))
+	reg.Acpi.PciEjected |= uint32(value << (8 * offset))
+	return nil
+}
+
+func (reg *AcpiPciPresent) Read(offset uint64, size uint) (uint64, error) {
+	reg.Acpi.lock.Lock()
+	defer reg.Acpi.lock.Unlock()
+
+	return uint64(reg.Acpi.pciPresent()) >> (8 * offset), nil
+}
+
+func (reg *AcpiPciPresent) Write(offset uint64, size uint, value uint64) error {
+	return nil
+}
+
+func (acpi *Acpi) pciPresent() uint32 {
+	// NOTE: Called with the lock held.
+	// Devices are only added or removed with
+	// the vcpus paused, so the bus is stable.
+	if acpi.model == nil {
+		return 0
+	}
+	pcibus, err := acpi.model.pciBus()
+	if err != nil {
+		return 0
+	}
+	present := uint32(0)
+	for slot, device := range pcibus.devices {
+		if device != nil {
+			present |= 1 << uint(slot)
+		}
+	}
+	return present & ^acpi.PciEjected
+}
+
+func (acpi *Acpi) Reset(vm *platform.Vm) error {
+	acpi.lock.Lock()
+	defer acpi.lock.Unlock()
+
+	acpi.Pm1Status = 0
+	acpi.Pm1Enable = 0
+	acpi.Pm1Control = AcpiPm1ControlSCIEN
+	acpi.GpeStatus = 0
+	acpi.GpeEnable = 0
+	acpi.PciUp = 0
+	acpi.PciDown = 0
+	return acpi.updateSci()
+}
+
+func (acpi *Acpi) powerOff() {
+	// NOTE: Called with the lock held.
+	if !acpi.is_off {
+		acpi.is_off = true
+		close(acpi.off)
+	}
+}
+
+//
+// PowerButton --
+//
+// Press the virtual power button. Whether this
+// does anything is entirely up to the guest.
+//
+func (acpi *Acpi) PowerButton() error {
+	acpi.lock.Lock()
+	defer acpi.lock.Unlock()
+
+	acpi.Pm1Status |= AcpiPm1StatusPWRBTN
+	return acpi.updateSci()
+}
+
+//
+// PciCheck --
+//
+// Ask the guest to check the given slot (i.e.
+// a device has been added). The guest will scan
+// the slot and load the appropriate driver.
+//
+func (acpi *Acpi) PciCheck(slot int) error {
+	acpi.lock.Lock()
+	defer acpi.lock.Unlock()
+
+	acpi.PciEjected &= ^(uint32(1) << uint(slot))
+	acpi.PciUp |= 1 << uint(slot)
+	acpi.GpeStatus |= 1 << AcpiHotplugGpe
+	return acpi.updateSci()
+}
+
+//
+// PciEject --
+//
+// Ask the guest to eject the given slot. Once
+// the driver has released the device, the guest
+// will write the slot to the eject register.
+//
+func (acpi *Acpi) PciEject(slot int) error {
+	acpi.lock.Lock()
+	defer acpi.lock.Unlock()
+
+	acpi.PciDown |= 1 << uint(slot)
+	acpi.GpeStatus |= 1 << AcpiHotplugGpe
+	return acpi.updateSci()
+}
+
+//
+// PciRemoved --
+//
+// The device in the given slot is gone.
+//
+func (acpi *Acpi) PciRemoved(slot int) {
+	acpi.lock.Lock()
+	defer acpi.lock.Unlock()
+
+	acpi.PciEjected &= ^(uint32(1) << uint(slot))
+}
+
+//
+// IsEjected --
+//
+// Has the guest ejected the given slot?
+//
+func (acpi *Acpi) IsEjected(slot int) bool {
+	acpi.lock.Lock()
+	defer acpi.lock.Unlock()
+
+	return acpi.PciEjected&(1<<uint(slot)) != 0
+}
+
+//
+// PowerOff --
+//
+// Pull the plug. This is the same as the guest
+// entering S5, except that it doesn't ask.
+//
+func (acpi *Acpi) PowerOff() {
+	acpi.lock.Lock()
+	defer acpi.lock.Unlock()
+
+	acpi.powerOff()
+}
+
+//
+// PoweredOff --
+//
+// A channel that is closed when power is off.
+//
+func (acpi *Acpi) PoweredOff() <-chan bool {
+	return acpi.off
+}
+
 func (acpi *Acpi) Attach(vm *platform.Vm, model *Model) error {
 
 	// Do we already have data?
 	rebuild := true
 	if acpi.Data == nil {
 		// Create our data.
-		acpi.Data = make([]byte, platform.PageSize, platform.PageSize)
+		// The DSDT has an entry for every PCI slot,
+		// so this needs a little more than a page.
+		acpi.Data = make([]byte, AcpiDataSize, AcpiDataSize)
 	} else {
 		// Align our data.
 		// This is necessary because we map this in
@@ -54,18 +494,34 @@ func (acpi *Acpi) Attach(vm *platform.Vm, model *Model) error {
 		rebuild = false
 	}
 
+	// Save our vm (for interrupts).
+	acpi.vm = vm
+	acpi.model = model
+
 	// Allocate our memory block.
 	err := model.Reserve(
 		vm,
 		acpi,
 		MemoryTypeAcpi,
 		acpi.Addr,
-		platform.PageSize,
+		uint64(len(acpi.Data)),
 		acpi.Data)
 	if err != nil {
 		return err
 	}
 
+	// Attach our power management registers.
+	err = acpi.PioDevice.Attach(vm, model)
+	if err != nil {
+		return err
+	}
+
+	// Restore the SCI line.
+	err = acpi.updateSci()
+	if err != nil {
+		return err
+	}
+
 	// Already done.
 	if !rebuild {
 		return nil
@@ -93,6 +549,7 @@ func (acpi *Acpi) Attach(vm *platform.Vm, model *Model) error {
 		C.int(len(vm.Vcpus())),
 		C.__u32(IOApic),
 		C.__u32(0), // I/O APIC interrupt?
+		C.__u8(AcpiSciInterrupt),
 	)
 	acpi.Debug("MADT %x @ %x", madt_bytes, acpi.Addr)
 
@@ -106,6 +563,10 @@ func (acpi *Acpi) Attach(vm *platform.Vm, model *Model) error {
 	dsdt_address := uint64(acpi.Addr) + uint64(offset)
 	dsdt_bytes := C.build_dsdt(
 		unsafe.Pointer(&acpi.Data[int(offset)]),
+		C.__u8(AcpiS5Type),
+		C.__u16(AcpiPmBase+AcpiHotplugOffset),
+		C.__u8(AcpiHotplugGpe),
+		C.int(PciMaxSlots),
 	)
 	acpi.Debug("DSDT %x @ %x", dsdt_bytes, dsdt_address)
 
@@ -115,11 +576,33 @@ func (acpi *Acpi) Attach(vm *platform.Vm, model *Model) error {
 		offset += 64 - (offset % 64)
 	}
 
+	// Load the FADT.
+	fadt_address := uint64(acpi.Addr) + uint64(offset)
+	fadt_bytes := C.build_fadt(
+		unsafe.Pointer(&acpi.Data[int(offset)]),
+		C.__u32(dsdt_address),
+		C.__u16(AcpiSciInterrupt),
+		C.__u32(AcpiPmBase),   // PM1 event block.
+		C.__u32(AcpiPmBase+4), // PM1 control block.
+		C.__u32(AcpiPmBase+6), // Reset register.
+		C.__u8(AcpiResetValue),
+		C.__u32(AcpiPmBase+AcpiGpe0Offset), // GPE0 block.
+		C.__u8(AcpiGpe0Length),
+	)
+	acpi.Debug("FADT %x @ %x", fadt_bytes, fadt_address)
+
+	// Align offset.
+	offset += fadt_bytes
+	if offset%64 != 0 {
+		offset += 64 - (offset % 64)
+	}
+
 	// Load the XSDT.
 	xsdt_address := uint64(acpi.Addr) + uint64(offset)
 	xsdt_bytes := C.build_xsdt(
 		unsafe.Pointer(&acpi.Data[int(offset)]),
-		C.__u64(acpi.Addr), // MADT address.
+		C.__u64(acpi.Addr),    // MADT address.
+		C.__u64(fadt_address), // FADT address.
 	)
 	acpi.Debug("XSDT %x @ %x", xsdt_bytes, xsdt_address)
 
@@ -133,7 +616,8 @@ func (acpi *Acpi) Attach(vm *platform.Vm, model *Model) error {
 	rsdt_address := uint64(acpi.Addr) + uint64(offset)
 	rsdt_bytes := C.build_rsdt(
 		unsafe.Pointer(&acpi.Data[int(offset)]),
-		C.__u32(acpi.Addr), // MADT address.
+		C.__u32(acpi.Addr),    // MADT address.
+		C.__u32(fadt_address), // FADT address.
 	)
 	acpi.Debug("RSDT %x @ %x", rsdt_bytes, rsdt_address)
 
@@ -155,3 +639,55 @@ func (acpi *Acpi) Attach(vm *platform.Vm, model *Model) error {
 	// Everything went okay.
 	return nil
 }
+
+func (model *Model) acpi() *Acpi {
+	for _, device := range model.Devices() {
+		acpi, ok := device.(*Acpi)
+		if ok {
+			return acpi
+		}
+	}
+	return nil
+}
+
+//
+// PowerButton --
+//
+// Press the power button (if we have one).
+//
+func (model *Model) PowerButton() error {
+	acpi := model.acpi()
+	if acpi == nil {
+		return AcpiNotFound
+	}
+	return acpi.PowerButton()
+}
+
+//
+// PowerOff --
+//
+// Force the power off (if we have ACPI).
+//
+func (model *Model) PowerOff() error {
+	acpi := model.acpi()
+	if acpi == nil {
+		return AcpiNotFound
+	}
+	acpi.PowerOff()
+	return nil
+}
+
+//
+// PoweredOff --
+//
+// Returns a channel that is closed when the machine
+// powers off. If there is no ACPI device, then this
+// will be nil (and the machine never powers off).
+//
+func (model *Model) PoweredOff() <-chan bool {
+	acpi := model.acpi()
+	if acpi == nil {
+		return nil
+	}
+	return acpi.PoweredOff()
+}
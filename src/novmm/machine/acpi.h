@@ -20,11 +20,13 @@
 
 long build_rsdp(void* start, __u32 rsdt_address, __u64 xsdt_address);
 
-long build_rsdt(void* start, __u32 madt_address);
-long build_xsdt(void* start, __u64 madt_address);
+long build_rsdt(void* start, __u32 madt_address, __u32 fadt_address);
+long build_xsdt(void* start, __u64 madt_address, __u64 fadt_address);
 
-long build_dsdt(void* start);
+long build_dsdt(void* start, __u8 s5_type, __u16 hotplug_address, __u8 hotplug_gpe, int slots);
+long build_fadt(void* start, __u32 dsdt_address, __u16 sci_interrupt, __u32 pm1_evt_address, __u32 pm1_cnt_address, __u32 reset_address, __u8 reset_value, __u32 gpe0_address, __u8 gpe0_len);
 
 long build_madt_device_lapic(void* start, __u8 processor_id, __u8 apic_id);
 long build_madt_device_ioapic(void* start, __u8 ioapic_id, __u32 address, __u32 interrupt);
-long build_madt(void* start, __u32 lapic_address, int vcpus, __u32 ioapic_address, __u32 ioapic_interrupt);
+long build_madt_device_iso(void* start, __u8 source, __u32 interrupt, __u16 flags);
+long build_madt(void* start, __u32 lapic_address, int vcpus, __u32 ioapic_address, __u32 ioapic_interrupt, __u8 sci_interrupt);
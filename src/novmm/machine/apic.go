@@ -29,6 +29,9 @@ type Apic struct {
 
 	// Our platform APIC.
 	State platform.IrqChip `json:"state"`
+
+	// Our power-on state (see Reset()).
+	reset_state platform.IrqChip
 }
 
 func NewApic(info *DeviceInfo) (Device, error) {
@@ -73,6 +76,12 @@ func (apic *Apic) Attach(vm *platform.Vm, model *Model) error {
 		return err
 	}
 
+	// Save the power-on state.
+	apic.reset_state, err = vm.GetIrqChip()
+	if err != nil {
+		return err
+	}
+
 	// We're good.
 	return nil
 }
@@ -95,3 +104,8 @@ func (apic *Apic) Load(vm *platform.Vm) error {
 	// Load state.
 	return vm.SetIrqChip(apic.State)
 }
+
+func (apic *Apic) Reset(vm *platform.Vm) error {
+	// Reset state.
+	return vm.SetIrqChip(apic.reset_state)
+}
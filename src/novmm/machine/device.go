@@ -43,6 +43,20 @@ type BaseDevice struct {
 	// just a straight-forward RWMUtex.
 	pause_lock sync.Mutex
 	run_lock   sync.RWMutex
+
+	// Our I/O statistics.
+	stats DeviceStats
+}
+
+//
+// DeviceStats --
+//
+// Simple counters for I/O requests.
+// These are updated atomically by IoHandler.Run().
+//
+type DeviceStats struct {
+	Reads  uint64 `json:"reads"`
+	Writes uint64 `json:"writes"`
 }
 
 type Device interface {
@@ -53,8 +67,10 @@ type Device interface {
 	MmioHandlers() IoHandlers
 
 	Attach(vm *platform.Vm, model *Model) error
+	Detach(vm *platform.Vm, model *Model) error
 	Load(vm *platform.Vm) error
 	Save(vm *platform.Vm) error
+	Reset(vm *platform.Vm) error
 
 	Pause(manual bool) error
 	Unpause(manual bool) error
@@ -64,6 +80,8 @@ type Device interface {
 
 	Interrupt() error
 
+	Stats() *DeviceStats
+
 	Debug(format string, v ...interface{})
 	IsDebugging() bool
 	SetDebugging(debug bool)
@@ -96,6 +114,10 @@ func (device *BaseDevice) Attach(vm *platform.Vm, model *Model) error {
 	return nil
 }
 
+func (device *BaseDevice) Detach(vm *platform.Vm, model *Model) error {
+	return nil
+}
+
 func (device *BaseDevice) Load(vm *platform.Vm) error {
 	return nil
 }
@@ -104,6 +126,10 @@ func (device *BaseDevice) Save(vm *platform.Vm) error {
 	return nil
 }
 
+func (device *BaseDevice) Reset(vm *platform.Vm) error {
+	return nil
+}
+
 func (device *BaseDevice) Pause(manual bool) error {
 	device.pause_lock.Lock()
 	defer device.pause_lock.Unlock()
@@ -171,6 +197,10 @@ func (device *BaseDevice) Interrupt() error {
 	return nil
 }
 
+func (device *BaseDevice) Stats() *DeviceStats {
+	return &device.stats
+}
+
 func (device *BaseDevice) Debug(format string, v ...interface{}) {
 	if device.IsDebugging() {
 		log.Printf(device.Name()+": "+format, v...)
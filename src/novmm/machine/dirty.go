@@ -0,0 +1,92 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine
+
+import (
+	"novmm/platform"
+	"sort"
+	"sync"
+)
+
+//
+// DirtyTracker --
+//
+// The platform will log pages written by the guest,
+// but devices write directly to guest memory via the
+// mappings returned by Map(). Devices that do this note
+// the regions they touch here, so that anything copying
+// guest memory (i.e. migration) can pick them up.
+//
+// Note that tracked pages are only released when they
+// are collected. Devices may hold on to mappings and write
+// at any point later on, so the caller should only collect
+// once all devices have been paused.
+//
+type DirtyTracker struct {
+	// Are we tracking?
+	enabled bool
+
+	// Pages touched.
+	pages map[platform.Paddr]bool
+
+	// Our lock.
+	lock sync.Mutex
+}
+
+func (tracker *DirtyTracker) Track(enabled bool) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	tracker.enabled = enabled
+	if enabled {
+		tracker.pages = make(map[platform.Paddr]bool)
+	} else {
+		tracker.pages = nil
+	}
+}
+
+func (tracker *DirtyTracker) MarkDirty(addr platform.Paddr, size uint64) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	if !tracker.enabled || size == 0 {
+		return
+	}
+
+	// Mark every page in the range.
+	start := addr.Align(platform.PageSize, false)
+	end := addr.After(size)
+	for page := start; page < end; page = page.After(platform.PageSize) {
+		tracker.pages[page] = true
+	}
+}
+
+func (tracker *DirtyTracker) Collect() []platform.Paddr {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	dirty := make([]platform.Paddr, 0, len(tracker.pages))
+	for page, _ := range tracker.pages {
+		dirty = append(dirty, page)
+	}
+	sort.Sort(platform.PaddrList(dirty))
+
+	// Start again.
+	if tracker.enabled {
+		tracker.pages = make(map[platform.Paddr]bool)
+	}
+
+	return dirty
+}
@@ -39,6 +39,18 @@ var PciInvalidAddress = errors.New("Invalid PCI address!")
 var PciBusNotFound = errors.New("Requested PCI devices, but no bus found?")
 var PciMSIError = errors.New("MSI internal error?")
 var PciCapabilityMismatch = errors.New("Capability mismatch!")
+var PciBusFull = errors.New("No free PCI slots?")
+var PciSlotInUse = errors.New("PCI slot already in use?")
+
+// Hot-plug errors.
+var DeviceExists = errors.New("Device already exists?")
+var DeviceNotPci = errors.New("Device is not a PCI device?")
+var DeviceNotFound = errors.New("Device not found?")
+var DeviceNotNic = errors.New("Device is not a network device?")
+var DeviceNotFs = errors.New("Device is not a filesystem device?")
+
+// ACPI errors.
+var AcpiNotFound = errors.New("No ACPI device found?")
 
 // UART errors.
 var UartUnknown = errors.New("Unknown COM port.")
@@ -60,3 +72,9 @@ var VirtioUnsupportedVnetHeader = errors.New("Unsupported vnet header size.")
 // and value. This will reduce the number of kernel-user
 // switches necessary to handle that particular address.
 var SaveIO = errors.New("Save I/O request (internal error).")
+
+// System reset.
+// This is returned from a write handler when the guest
+// has asked for a reset (i.e. via the ACPI reset register).
+// The vcpu loop is expected to reset the whole machine.
+var SystemReset = errors.New("System reset requested.")
@@ -0,0 +1,212 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package machine
+
+import (
+	"novmm/platform"
+)
+
+//
+// Hot-plug --
+//
+// Devices may be added and removed at runtime, but only
+// PCI devices (as there is no way for the guest to discover
+// other devices). The caller is responsible for ensuring
+// that the vcpus are paused while this happens.
+//
+// The guest is notified via ACPI (see Acpi.PciCheck and
+// Acpi.PciEject). Without an ACPI device, added devices
+// will only be found if the guest rescans the bus itself,
+// and devices cannot be removed.
+//
+
+type pciDeviceOwner interface {
+	pciDevice() *PciDevice
+}
+
+func (model *Model) pciBus() (*PciBus, error) {
+	for _, device := range model.Devices() {
+		if pcibus, ok := device.(*PciBus); ok {
+			return pcibus, nil
+		}
+	}
+	return nil, PciBusNotFound
+}
+
+func (model *Model) Lookup(name string) Device {
+	for _, device := range model.Devices() {
+		if device.Name() == name {
+			return device
+		}
+	}
+	return nil
+}
+
+func (model *Model) lookupPci(name string) (Device, *PciDevice, error) {
+
+	device := model.Lookup(name)
+	if device == nil {
+		return nil, nil, DeviceNotFound
+	}
+	owner, ok := device.(pciDeviceOwner)
+	if !ok || owner.pciDevice() == nil {
+		return nil, nil, DeviceNotPci
+	}
+
+	return device, owner.pciDevice(), nil
+}
+
+func (model *Model) PciSlot(name string) (int, error) {
+
+	_, pcidevice, err := model.lookupPci(name)
+	if err != nil {
+		return -1, err
+	}
+
+	return pcidevice.Slot, nil
+}
+
+func (model *Model) EjectDevice(name string) error {
+
+	_, pcidevice, err := model.lookupPci(name)
+	if err != nil {
+		return err
+	}
+	acpi := model.acpi()
+	if acpi == nil {
+		return AcpiNotFound
+	}
+
+	return acpi.PciEject(pcidevice.Slot)
+}
+
+func (model *Model) IsReleased(name string) (bool, error) {
+
+	device, pcidevice, err := model.lookupPci(name)
+	if err != nil {
+		return false, err
+	}
+
+	// Has the guest ejected the slot?
+	acpi := model.acpi()
+	if acpi == nil {
+		return false, AcpiNotFound
+	}
+	if !acpi.IsEjected(pcidevice.Slot) {
+		return false, nil
+	}
+
+	// Only virtio devices hold guest state.
+	if virtio, ok := device.(interface {
+		IsReleased() bool
+	}); ok {
+		return virtio.IsReleased(), nil
+	}
+
+	return true, nil
+}
+
+func (model *Model) AddDevice(
+	vm *platform.Vm,
+	info DeviceInfo) (Device, int, error) {
+
+	model.hotplug_lock.Lock()
+	defer model.hotplug_lock.Unlock()
+
+	if model.Lookup(info.Name) != nil {
+		return nil, -1, DeviceExists
+	}
+
+	// Do we have a bus?
+	_, err := model.pciBus()
+	if err != nil {
+		return nil, -1, err
+	}
+
+	device, err := info.Load()
+	if err != nil {
+		return nil, -1, err
+	}
+	owner, ok := device.(pciDeviceOwner)
+	if !ok || owner.pciDevice() == nil {
+		return nil, -1, DeviceNotPci
+	}
+	if info.Debug {
+		device.SetDebugging(info.Debug)
+	}
+
+	// Attach it.
+	// This will place the device on the bus.
+	// If anything fails, we undo whatever the device
+	// has done (i.e. taken a slot on the bus).
+	err = device.Attach(vm, model)
+	if err != nil {
+		device.Detach(vm, model)
+		return nil, -1, err
+	}
+	model.appendDevice(device)
+	slot := owner.pciDevice().Slot
+
+	err = model.flush()
+	if err != nil {
+		device.Detach(vm, model)
+		model.removeDevice(device)
+		model.flush()
+		return nil, -1, err
+	}
+
+	// Let the guest know.
+	if acpi := model.acpi(); acpi != nil {
+		err = acpi.PciCheck(slot)
+	}
+
+	return device, slot, err
+}
+
+func (model *Model) RemoveDevice(vm *platform.Vm, name string) error {
+
+	model.hotplug_lock.Lock()
+	defer model.hotplug_lock.Unlock()
+
+	device, pcidevice, err := model.lookupPci(name)
+	if err != nil {
+		return err
+	}
+	slot := pcidevice.Slot
+
+	// Quiesce the device.
+	// Once detached, the device will not run again,
+	// but we unpause it so that any goroutines waiting
+	// on the device are able to see this and exit.
+	err = device.Pause(false)
+	if err != nil {
+		return err
+	}
+	err = device.Detach(vm, model)
+	device.Unpause(false)
+	if err != nil {
+		return err
+	}
+
+	// Drop it from our list.
+	model.removeDevice(device)
+
+	// The slot is free again.
+	if acpi := model.acpi(); acpi != nil {
+		acpi.PciRemoved(slot)
+	}
+
+	return model.flush()
+}
@@ -16,6 +16,7 @@ package machine
 
 import (
 	"novmm/platform"
+	"sync/atomic"
 )
 
 //
@@ -144,6 +145,7 @@ func (io *IoHandler) Run() {
 		// Perform the operation.
 		if req.event.IsWrite() {
 			val := normalize(req.event.GetData(), size)
+			atomic.AddUint64(&io.Device.Stats().Writes, 1)
 
 			// Debug?
 			io.Debug(
@@ -156,6 +158,7 @@ func (io *IoHandler) Run() {
 			req.result <- err
 
 		} else {
+			atomic.AddUint64(&io.Device.Stats().Reads, 1)
 			val, err := io.operations.Read(req.offset, size)
 			val = normalize(val, size)
 			if err == nil {
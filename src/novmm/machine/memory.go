@@ -187,6 +187,23 @@ func (memory *MemoryMap) Reserve(
 	return err
 }
 
+func (memory *MemoryMap) Access(
+	addr platform.Paddr,
+	size uint64) ([]byte, error) {
+
+	// Find any region backed by memory.
+	// Unlike Map(), this doesn't care about
+	// the type or any allocations in the region.
+	for _, region := range *memory {
+		if region.Contains(addr, size) && region.user != nil {
+			addr_offset := uint64(addr - region.Start)
+			return region.user[addr_offset : addr_offset+size], nil
+		}
+	}
+
+	return nil, MemoryNotFound
+}
+
 func (memory *MemoryMap) Map(
 	memtype MemoryType,
 	addr platform.Paddr,
@@ -260,6 +277,13 @@ func (memory *MemoryMap) Allocate(
 	return platform.Paddr(0), nil, MemoryNotFound
 }
 
+func (memory *MemoryMap) FreeAll() {
+
+	for _, region := range *memory {
+		region.allocated = make(map[uint64]uint64)
+	}
+}
+
 func (memory *MemoryMap) Load(
 	start platform.Paddr,
 	end platform.Paddr,
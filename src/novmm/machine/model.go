@@ -15,7 +15,9 @@
 package machine
 
 import (
+	"log"
 	"novmm/platform"
+	"sync"
 )
 
 //
@@ -39,12 +41,27 @@ type Model struct {
 	// This maps interrupts to devices.
 	InterruptMap
 
+	// Pages written by devices.
+	DirtyTracker
+
 	// All devices.
+	// The slice is never modified in place (it is
+	// replaced on hot-plug), so the result of Devices()
+	// may be used without holding the lock.
 	devices []Device
 
+	// Protects devices.
+	lock sync.Mutex
+
+	// Serializes hot-plug (see AddDevice).
+	hotplug_lock sync.Mutex
+
 	// Our device lookup cache.
 	pio_cache  *IoCache
 	mmio_cache *IoCache
+
+	// Our handler for asynchronous device errors.
+	error_handler func(Device, error)
 }
 
 func NewModel(vm *platform.Vm) (*Model, error) {
@@ -69,7 +86,7 @@ func (model *Model) flush() error {
 
 	collectIoHandlers := func(is_pio bool) []IoHandlers {
 		io_handlers := make([]IoHandlers, 0, 0)
-		for _, device := range model.devices {
+		for _, device := range model.Devices() {
 			if is_pio {
 				io_handlers = append(io_handlers, device.PioHandlers())
 			} else {
@@ -87,13 +104,55 @@ func (model *Model) flush() error {
 	return nil
 }
 
+func (model *Model) SetErrorHandler(handler func(Device, error)) {
+	model.error_handler = handler
+}
+
+func (model *Model) DeviceError(device Device, err error) {
+
+	// These errors happen outside of any vcpu,
+	// (i.e. in goroutines processing requests) so
+	// there is nobody to return them to.
+	log.Printf("Device %s error: %s", device.Name(), err.Error())
+	if model.error_handler != nil {
+		model.error_handler(device, err)
+	}
+}
+
 func (model *Model) Devices() []Device {
+	model.lock.Lock()
+	defer model.lock.Unlock()
 	return model.devices
 }
 
+func (model *Model) setDevices(devices []Device) {
+	model.lock.Lock()
+	defer model.lock.Unlock()
+	model.devices = devices
+}
+
+func (model *Model) appendDevice(device Device) {
+	old_devices := model.Devices()
+	devices := make([]Device, 0, len(old_devices)+1)
+	devices = append(devices, old_devices...)
+	model.setDevices(append(devices, device))
+}
+
+func (model *Model) removeDevice(device Device) {
+	old_devices := model.Devices()
+	devices := make([]Device, 0, len(old_devices))
+	for _, other := range old_devices {
+		if other != device {
+			devices = append(devices, other)
+		}
+	}
+	model.setDevices(devices)
+}
+
 func (model *Model) Pause(manual bool) error {
 
-	for i, device := range model.devices {
+	devices := model.Devices()
+	for i, device := range devices {
 		// Ensure all devices are paused.
 		err := device.Pause(manual)
 		if err != nil && err != DeviceAlreadyPaused {
@@ -110,7 +169,8 @@ func (model *Model) Pause(manual bool) error {
 
 func (model *Model) Unpause(manual bool) error {
 
-	for i, device := range model.devices {
+	devices := model.Devices()
+	for i, device := range devices {
 		// Ensure all devices are unpaused.
 		err := device.Unpause(manual)
 		if err != nil && err != DeviceAlreadyPaused {
@@ -127,7 +187,7 @@ func (model *Model) Unpause(manual bool) error {
 
 func (model *Model) Load(vm *platform.Vm) error {
 
-	for _, device := range model.devices {
+	for _, device := range model.Devices() {
 		// Load our device state.
 		err := device.Load(vm)
 		if err != nil {
@@ -140,7 +200,7 @@ func (model *Model) Load(vm *platform.Vm) error {
 
 func (model *Model) Save(vm *platform.Vm) error {
 
-	for _, device := range model.devices {
+	for _, device := range model.Devices() {
 		// Synchronize our device state.
 		err := device.Save(vm)
 		if err != nil {
@@ -151,6 +211,30 @@ func (model *Model) Save(vm *platform.Vm) error {
 	return nil
 }
 
+func (model *Model) Reset(vm *platform.Vm) error {
+
+	err := model.Pause(false)
+	if err != nil {
+		return err
+	}
+	defer model.Unpause(false)
+
+	for _, device := range model.Devices() {
+		// Put the device in its power-on state.
+		err := device.Reset(vm)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Release everything loaded into memory.
+	// Nothing is cleared, but the next load
+	// will be free to allocate anywhere.
+	model.FreeAll()
+
+	return nil
+}
+
 func (model *Model) DeviceInfo(vm *platform.Vm) ([]DeviceInfo, error) {
 
 	err := model.Pause(false)
@@ -165,8 +249,9 @@ func (model *Model) DeviceInfo(vm *platform.Vm) ([]DeviceInfo, error) {
 		return nil, err
 	}
 
-	devices := make([]DeviceInfo, 0, len(model.devices))
-	for _, device := range model.devices {
+	model_devices := model.Devices()
+	devices := make([]DeviceInfo, 0, len(model_devices))
+	for _, device := range model_devices {
 
 		// Get the deviceinfo.
 		deviceinfo, err := NewDeviceInfo(device)
@@ -121,6 +121,12 @@ type PciDevice struct {
 	// call RefreshCapabilities to reload the map.
 	Capabilities PciCapabilityMap `json:"capabilities"`
 
+	// Our slot on the bus.
+	// This is assigned on first attach, and saved
+	// so that devices keep their slot if others are
+	// removed (i.e. hot-unplugged) in the meantime.
+	Slot int `json:"slot"`
+
 	// Bar sizes and operations.
 	PciBarCount uint `json:"-"`
 	PciBarSizes `json:"-"`
@@ -174,6 +180,9 @@ const (
 	PciFunctionVendor = 0
 )
 
+// Our (flat) bus has 32 device slots.
+const PciMaxSlots = 32
+
 type PciConfAddr struct {
 	*PciBus
 }
@@ -209,7 +218,8 @@ func (pcibus *PciBus) selectLast() error {
 		pcibus.last = nil
 		return nil
 	}
-	if len(pcibus.devices) <= int(device) {
+	if len(pcibus.devices) <= int(device) ||
+		pcibus.devices[device] == nil {
 		pcibus.last = nil
 		return nil
 	}
@@ -337,14 +347,37 @@ func NewPciDevice(
 	// (This is different only for bridges, etc.)
 	device.PciBarCount = 6
 
+	// Not yet on a bus.
+	device.Slot = -1
+
 	// Return the pci device.
 	return device, nil
 }
 
 func (pcibus *PciBus) AddDevice(device *PciDevice) error {
 
-	// Append it to our list.
-	pcibus.devices = append(pcibus.devices, device)
+	// Pick a slot, if we don't have one.
+	if device.Slot < 0 {
+		device.Slot = len(pcibus.devices)
+		for slot, other := range pcibus.devices {
+			if other == nil {
+				device.Slot = slot
+				break
+			}
+		}
+	}
+	if device.Slot >= PciMaxSlots {
+		return PciBusFull
+	}
+
+	// Put it in our list.
+	for len(pcibus.devices) <= device.Slot {
+		pcibus.devices = append(pcibus.devices, nil)
+	}
+	if pcibus.devices[device.Slot] != nil {
+		return PciSlotInUse
+	}
+	pcibus.devices[device.Slot] = device
 
 	// Rebuild our config-mappings.
 	device.RebuildBars()
@@ -352,6 +385,24 @@ func (pcibus *PciBus) AddDevice(device *PciDevice) error {
 	return pcibus.flush()
 }
 
+func (pcibus *PciBus) RemoveDevice(device *PciDevice) error {
+
+	// Is it ours?
+	if device.Slot < 0 ||
+		device.Slot >= len(pcibus.devices) ||
+		pcibus.devices[device.Slot] != device {
+		return PciInvalidAddress
+	}
+
+	// Leave a hole (see AddDevice).
+	pcibus.devices[device.Slot] = nil
+	if pcibus.last == device {
+		pcibus.last = nil
+	}
+
+	return pcibus.flush()
+}
+
 func (pcibus *PciBus) MmioHandlers() IoHandlers {
 	return pcibus.IoHandlers
 }
@@ -491,7 +542,7 @@ func (pcidevice *PciDevice) Attach(vm *platform.Vm, model *Model) error {
 	// Find our pcibus.
 	var ok bool
 	var pcibus *PciBus
-	for _, device := range model.devices {
+	for _, device := range model.Devices() {
 		pcibus, ok = device.(*PciBus)
 		if pcibus != nil && ok {
 			break
@@ -520,6 +571,21 @@ func (pcidevice *PciDevice) Attach(vm *platform.Vm, model *Model) error {
 	return pcibus.AddDevice(pcidevice)
 }
 
+func (pcidevice *PciDevice) Detach(vm *platform.Vm, model *Model) error {
+
+	pcibus, err := model.pciBus()
+	if err != nil {
+		return err
+	}
+
+	// Drop from the PciBus.
+	return pcibus.RemoveDevice(pcidevice)
+}
+
+func (pcidevice *PciDevice) pciDevice() *PciDevice {
+	return pcidevice
+}
+
 func (pcidevice *PciDevice) Interrupt() error {
 	return pcidevice.std_interrupt()
 }
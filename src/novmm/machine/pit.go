@@ -25,6 +25,9 @@ type Pit struct {
 	// Similar to the pit, we consider the platform
 	// PIT to be an intrinsic part of our "pit".
 	Pit platform.PitState `json:"pit"`
+
+	// Our power-on state (see Reset()).
+	reset_state platform.PitState
 }
 
 func NewPit(info *DeviceInfo) (Device, error) {
@@ -40,6 +43,12 @@ func (pit *Pit) Attach(vm *platform.Vm, model *Model) error {
 		return err
 	}
 
+	// Save the power-on state.
+	pit.reset_state, err = vm.GetPit()
+	if err != nil {
+		return err
+	}
+
 	// We're good.
 	return nil
 }
@@ -62,3 +71,8 @@ func (pit *Pit) Load(vm *platform.Vm) error {
 	// Load state.
 	return vm.SetPit(pit.Pit)
 }
+
+func (pit *Pit) Reset(vm *platform.Vm) error {
+	// Reset state.
+	return vm.SetPit(pit.reset_state)
+}
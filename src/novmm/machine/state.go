@@ -108,7 +108,7 @@ func (model *Model) CreateDevices(
 		}
 
 		// Add the device to our list.
-		model.devices = append(model.devices, device)
+		model.appendDevice(device)
 
 		// Is this a proxy?
 		if proxy == nil {
@@ -15,6 +15,7 @@
 package machine
 
 import (
+	"io"
 	"novmm/platform"
 	"sort"
 	"syscall"
@@ -162,10 +163,17 @@ func (user *UserMemory) Layout(
 			MemoryTypeUser,
 			last_top,
 			memory,
-			user.mmap[start:])
+			user.mmap[start:start+memory])
 		if err != nil {
 			return err
 		}
+
+		// Remember this.
+		user.Allocated = append(
+			user.Allocated,
+			UserMemorySegment{
+				start,
+				MemoryRegion{last_top, memory}})
 	}
 
 	// All is good.
@@ -221,3 +229,33 @@ func (user *UserMemory) Attach(vm *platform.Vm, model *Model) error {
 
 	return nil
 }
+
+func (user *UserMemory) Size() uint64 {
+	return uint64(len(user.mmap))
+}
+
+func (user *UserMemory) Lookup(addr platform.Paddr) (uint64, bool) {
+
+	// Find the segment containing this address.
+	for _, segment := range user.Allocated {
+		if segment.Region.Contains(addr, 1) {
+			return segment.Offset + addr.OffsetFrom(segment.Region.Start), true
+		}
+	}
+
+	return 0, false
+}
+
+func (user *UserMemory) ReadAt(data []byte, offset int64) (int, error) {
+
+	if offset >= int64(len(user.mmap)) {
+		return 0, io.EOF
+	}
+
+	n := copy(data, user.mmap[offset:])
+	if n < len(data) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
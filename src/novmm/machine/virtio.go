@@ -120,6 +120,12 @@ type VirtioChannel struct {
 	// Our outstanding buffers.
 	Outstanding VirtioBufferSet `json:"outstanding"`
 
+	// Our statistics (see ChannelStats()).
+	// These are not saved, and will restart at
+	// zero (for consumed) on load.
+	consumed    uint64
+	outstanding int64
+
 	// The queue size.
 	QueueSize Register `json:"queue-size"`
 
@@ -130,6 +136,12 @@ type VirtioChannel struct {
 	CfgVec   Register `json:"config-vector"`
 	QueueVec Register `json:"queue-vector"`
 
+	// Have we been stopped (see stop())?
+	stopped bool
+
+	// Our generation (bumped on reset()).
+	generation uint64
+
 	// Our underlying ring.
 	vring C.struct_vring
 }
@@ -166,6 +178,7 @@ func (vchannel *VirtioChannel) processOne(n uint16) error {
 		// Do we have a buffer?
 		if buf == nil {
 			buf = NewVirtioBuffer(uint16(index), !is_write)
+			buf.generation = vchannel.generation
 		}
 
 		if is_indirect {
@@ -206,7 +219,11 @@ func (vchannel *VirtioChannel) processOne(n uint16) error {
 				buf.index)
 
 			// Mark this as outstanding.
-			vchannel.Outstanding[uint16(buf.index)] = true
+			// Resubmitted buffers are already counted.
+			if !vchannel.Outstanding[uint16(buf.index)] {
+				vchannel.Outstanding[uint16(buf.index)] = true
+				atomic.AddInt64(&vchannel.outstanding, 1)
+			}
 			vchannel.incoming <- buf
 			break
 
@@ -244,6 +261,7 @@ func (vchannel *VirtioChannel) consumeOne() (bool, error) {
 
 		// We're up a buffer.
 		vchannel.Consumed += 1
+		atomic.AddUint64(&vchannel.consumed, 1)
 
 		// Process the buffer.
 		err := vchannel.processOne(uint16(index))
@@ -260,6 +278,11 @@ func (vchannel *VirtioChannel) consumeOne() (bool, error) {
 func (vchannel *VirtioChannel) consumeOutstanding() error {
 
 	// Resubmit outstanding buffers.
+	// Our count may be stale (i.e. after a restore),
+	// so we take it directly from the set.
+	atomic.StoreInt64(
+		&vchannel.outstanding,
+		int64(len(vchannel.Outstanding)))
 	for index, _ := range vchannel.Outstanding {
 		err := vchannel.processOne(index)
 		if err != nil {
@@ -276,6 +299,20 @@ func (vchannel *VirtioChannel) ProcessIncoming() error {
 		// The device is active.
 		vchannel.VirtioDevice.Acquire()
 
+		// Are we finished?
+		if vchannel.stopped {
+			vchannel.VirtioDevice.Release()
+			break
+		}
+
+		// Have we been reset (see reset())?
+		// We may have picked up a notification just
+		// before, and the old ring is no longer valid.
+		if vchannel.QueueAddress.Value == 0 {
+			vchannel.VirtioDevice.Release()
+			continue
+		}
+
 		// Reset our pending variable.
 		// A write to the notification register
 		// will drop a notification in the channel
@@ -293,6 +330,9 @@ func (vchannel *VirtioChannel) ProcessIncoming() error {
 			}
 		}
 
+		// We may have updated the event index.
+		vchannel.markRing()
+
 		// No longer active.
 		vchannel.VirtioDevice.Release()
 	}
@@ -306,6 +346,15 @@ func (vchannel *VirtioChannel) ProcessOutgoing() error {
 		// The device is active.
 		vchannel.VirtioDevice.Acquire()
 
+		// Drop any stragglers.
+		// This includes buffers that were outstanding
+		// when the channel was reset, which belong to a
+		// ring that no longer exists.
+		if vchannel.stopped || buf.generation != vchannel.generation {
+			vchannel.VirtioDevice.Release()
+			continue
+		}
+
 		// Put in the virtqueue.
 		vchannel.Debug(
 			"vqueue#%d outgoing slot [%d]",
@@ -335,8 +384,12 @@ func (vchannel *VirtioChannel) ProcessOutgoing() error {
 			}
 		}
 
+		// The used ring has been updated.
+		vchannel.markRing()
+
 		// Remove from our outstanding list.
 		delete(vchannel.Outstanding, uint16(buf.index))
+		atomic.AddInt64(&vchannel.outstanding, -1)
 
 		// We can release until the next buffer comes back.
 		vchannel.VirtioDevice.Release()
@@ -401,6 +454,12 @@ type VirtioDevice struct {
 
 	// Our host map function.
 	mmap func(platform.Paddr, uint64) ([]byte, error)
+
+	// Our dirty function (see DirtyTracker).
+	dirty func(platform.Paddr, uint64)
+
+	// Our error function (see Model.DeviceError).
+	error func(error)
 }
 
 //
@@ -614,6 +673,17 @@ func (reg *VirtioConf) Write(offset uint64, size uint, value uint64) error {
 	return nil
 }
 
+func (vchannel *VirtioChannel) markRing() {
+
+	if vchannel.QueueAddress.Value != 0 {
+		vchannel.VirtioDevice.dirty(
+			platform.Paddr(4096*vchannel.QueueAddress.Value),
+			uint64(C.vring_size(
+				C.uint(vchannel.QueueSize.Value),
+				platform.PageSize)))
+	}
+}
+
 func (vchannel *VirtioChannel) remap() error {
 
 	if vchannel.QueueAddress.Value != 0 {
@@ -649,6 +719,13 @@ func (vchannel *VirtioChannel) remap() error {
 	return nil
 }
 
+func (vchannel *VirtioChannel) run(process func() error) {
+	err := process()
+	if err != nil {
+		vchannel.VirtioDevice.error(err)
+	}
+}
+
 func (vchannel *VirtioChannel) start() error {
 
 	// Can't have size 0 or a non power of 2.
@@ -666,8 +743,8 @@ func (vchannel *VirtioChannel) start() error {
 
 	// Start our goroutine which will process outgoing buffers.
 	// This will add the outgoing buffers back into the vchannel.
-	go vchannel.ProcessOutgoing()
-	go vchannel.ProcessIncoming()
+	go vchannel.run(vchannel.ProcessOutgoing)
+	go vchannel.run(vchannel.ProcessIncoming)
 
 	// Is this a valid vqueue?
 	// If so, then we retrigger any outstanding buffers.
@@ -684,6 +761,45 @@ func (vchannel *VirtioChannel) start() error {
 	return nil
 }
 
+func (vchannel *VirtioChannel) stop() {
+
+	// NOTE: This must be called with the device paused.
+	// We close the incoming side, which will cause all
+	// device goroutines to exit. The outgoing channel is
+	// left open, as there may still be buffers in flight
+	// (i.e. blocked on a read from a tap device), but any
+	// remaining buffers will be discarded.
+	vchannel.stopped = true
+	close(vchannel.notifications)
+	close(vchannel.incoming)
+}
+
+func (vchannel *VirtioChannel) reset() {
+
+	// NOTE: This must be called with the device paused.
+	// Any buffers the device is still holding will be
+	// dropped when they come back (see ProcessOutgoing).
+	vchannel.generation += 1
+	vchannel.QueueAddress.Value = 0
+	vchannel.CfgVec.Value = 0
+	vchannel.QueueVec.Value = 0
+	vchannel.Consumed = 0
+	vchannel.Outstanding = make(VirtioBufferSet)
+	atomic.StoreInt64(&vchannel.outstanding, 0)
+	atomic.StoreInt32(&vchannel.pending, 0)
+
+	// Drop any pending notifications, and anything
+	// that has not yet been picked up by the device.
+	for draining := true; draining; {
+		select {
+		case <-vchannel.notifications:
+		case <-vchannel.incoming:
+		default:
+			draining = false
+		}
+	}
+}
+
 func (vchannel *VirtioChannel) init() {
 	vchannel.incoming = make(chan *VirtioBuffer, vchannel.QueueSize.Value)
 	vchannel.outgoing = make(chan *VirtioBuffer, vchannel.QueueSize.Value)
@@ -812,7 +928,15 @@ func (virtio *VirtioDevice) Attach(vm *platform.Vm, model *Model) error {
 
 	// Save our map function.
 	virtio.mmap = func(addr platform.Paddr, size uint64) ([]byte, error) {
-		return model.Map(MemoryTypeUser, addr, size, false)
+		data, err := model.Map(MemoryTypeUser, addr, size, false)
+		if err == nil {
+			model.MarkDirty(addr, size)
+		}
+		return data, err
+	}
+	virtio.dirty = model.MarkDirty
+	virtio.error = func(err error) {
+		model.DeviceError(virtio, err)
 	}
 
 	// See if our device is an MSI device.
@@ -835,6 +959,83 @@ func (virtio *VirtioDevice) Attach(vm *platform.Vm, model *Model) error {
 	return virtio.Device.Attach(vm, model)
 }
 
+func (virtio *VirtioDevice) Detach(vm *platform.Vm, model *Model) error {
+
+	// Stop all our channels.
+	for _, vchannel := range virtio.Channels {
+		vchannel.stop()
+	}
+
+	return virtio.Device.Detach(vm, model)
+}
+
+func (virtio *VirtioDevice) Reset(vm *platform.Vm) error {
+
+	// Reset all our channels.
+	for _, vchannel := range virtio.Channels {
+		vchannel.reset()
+	}
+
+	// Reset our registers.
+	// This is what the guest sees after writing
+	// a zero status, and before it starts over.
+	virtio.GuestFeatures.Value = 0
+	virtio.QueueSelect.Value = 0
+	virtio.QueueNotify.Value = 0
+	virtio.DeviceStatus.Value = VirtioStatusReboot
+	virtio.IsrStatus.Value = 0
+
+	return virtio.Device.Reset(vm)
+}
+
+func (virtio *VirtioDevice) IsReleased() bool {
+
+	// Has the driver reset the device?
+	// On reset, all queues are also cleared.
+	if virtio.DeviceStatus.Value != VirtioStatusReboot {
+		return false
+	}
+	for _, vchannel := range virtio.Channels {
+		if vchannel.QueueAddress.Value != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// VirtioChannelStats --
+//
+// Buffer counts for a single channel.
+//
+type VirtioChannelStats struct {
+	Channel     uint   `json:"channel"`
+	Consumed    uint64 `json:"consumed"`
+	Outstanding int64  `json:"outstanding"`
+}
+
+func (virtio *VirtioDevice) ChannelStats() []VirtioChannelStats {
+
+	stats := make([]VirtioChannelStats, 0, len(virtio.Channels))
+	for _, vchannel := range virtio.Channels {
+		stats = append(stats, VirtioChannelStats{
+			Channel:     vchannel.Channel,
+			Consumed:    atomic.LoadUint64(&vchannel.consumed),
+			Outstanding: atomic.LoadInt64(&vchannel.outstanding),
+		})
+	}
+
+	return stats
+}
+
+func (virtio *VirtioDevice) pciDevice() *PciDevice {
+	if pcidevice, ok := virtio.Device.(pciDeviceOwner); ok {
+		return pcidevice.pciDevice()
+	}
+	return nil
+}
+
 func (virtio *VirtioDevice) IsMSIXEnabled() bool {
 	return virtio.msix != nil && virtio.msix.IsMSIXEnabled()
 }
@@ -133,6 +133,21 @@ func NewVirtioPciBlock(info *DeviceInfo) (Device, error) {
 	return &VirtioBlockDevice{VirtioDevice: device}, err
 }
 
+func (block *VirtioBlockDevice) Detach(vm *platform.Vm, model *Model) error {
+	err := block.VirtioDevice.Detach(vm, model)
+	if err != nil {
+		return err
+	}
+
+	// Close our backing file.
+	if block.Fd >= 0 {
+		syscall.Close(block.Fd)
+		block.Fd = -1
+	}
+
+	return nil
+}
+
 func (block *VirtioBlockDevice) Attach(vm *platform.Vm, model *Model) error {
 	err := block.VirtioDevice.Attach(vm, model)
 	if err != nil {
@@ -36,6 +36,9 @@ type VirtioBuffer struct {
 	index    uint16
 	length   int
 	readonly bool
+
+	// The channel generation (see VirtioChannel.reset()).
+	generation uint64
 }
 
 func NewVirtioBuffer(index uint16, readonly bool) *VirtioBuffer {
@@ -15,6 +15,7 @@
 package machine
 
 import (
+	"io"
 	"novmm/platform"
 	"sync"
 )
@@ -52,7 +53,11 @@ func (device *VirtioConsoleDevice) sendCtrl(
 	event int,
 	value int) error {
 
-	buf := <-device.Channels[2].incoming
+	// The channel is closed if the device is removed.
+	buf, ok := <-device.Channels[2].incoming
+	if !ok {
+		return io.ErrClosedPipe
+	}
 
 	header := &Ram{buf.Map(0, 8)}
 
@@ -206,9 +211,22 @@ func (console *VirtioConsoleDevice) Read(p []byte) (int, error) {
 	console.read_lock.Lock()
 	defer console.read_lock.Unlock()
 
+	// Drop anything left over from before a reset.
+	// (It will be discarded in ProcessOutgoing.)
+	if console.read_buf != nil &&
+		console.read_buf.generation != console.Channels[1].generation {
+		console.Channels[1].outgoing <- console.read_buf
+		console.read_buf = nil
+		console.read_offset = 0
+	}
+
 	// Need a new buffer?
 	if console.read_buf == nil {
-		console.read_buf = <-console.Channels[1].incoming
+		buf, ok := <-console.Channels[1].incoming
+		if !ok {
+			return 0, io.EOF
+		}
+		console.read_buf = buf
 	}
 
 	// Copy out as much as possible.
@@ -234,7 +252,10 @@ func (console *VirtioConsoleDevice) Write(p []byte) (int, error) {
 	for n < len(p) {
 
 		// Always grab a new buffer.
-		buf := <-console.Channels[0].incoming
+		buf, ok := <-console.Channels[0].incoming
+		if !ok {
+			return n, io.ErrClosedPipe
+		}
 
 		// Map as much as needed.
 		left := len(p) - n
@@ -15,7 +15,6 @@
 package machine
 
 import (
-	"log"
 	"novmm/plan9"
 	"novmm/platform"
 )
@@ -64,7 +63,7 @@ func (fs *VirtioFsDevice) process(buf *VirtioBuffer) {
 	// Handle our request.
 	err := fs.Fs.Handle(req, resp, fs.Debugfs)
 	if err != nil {
-		log.Printf("FS error: %s", err.Error())
+		fs.VirtioDevice.error(err)
 	}
 
 	// Finished request.
@@ -73,10 +72,7 @@ func (fs *VirtioFsDevice) process(buf *VirtioBuffer) {
 
 func (fs *VirtioFsDevice) run() error {
 
-	for {
-		// Read a request.
-		req := <-fs.VirtioDevice.Channels[0].incoming
-
+	for req := range fs.VirtioDevice.Channels[0].incoming {
 		// Process it.
 		go fs.process(req)
 	}
@@ -98,6 +94,23 @@ func setupFs(device *VirtioDevice) (Device, error) {
 	return fs, fs.Init()
 }
 
+//
+// FsTag --
+//
+// Returns the tag of the given filesystem device.
+//
+func (model *Model) FsTag(name string) (string, error) {
+	device := model.Lookup(name)
+	if device == nil {
+		return "", DeviceNotFound
+	}
+	fs, ok := device.(*VirtioFsDevice)
+	if !ok {
+		return "", DeviceNotFs
+	}
+	return fs.Tag, nil
+}
+
 func NewVirtioMmioFs(info *DeviceInfo) (Device, error) {
 	device, err := NewMmioVirtioDevice(info, VirtioType9p)
 	if err != nil {
@@ -145,3 +158,15 @@ func (fs *VirtioFsDevice) Attach(vm *platform.Vm, model *Model) error {
 
 	return nil
 }
+
+func (fs *VirtioFsDevice) Detach(vm *platform.Vm, model *Model) error {
+	err := fs.VirtioDevice.Detach(vm, model)
+	if err != nil {
+		return err
+	}
+
+	// Close all open files.
+	fs.Fs.Close()
+
+	return nil
+}
@@ -18,6 +18,7 @@ import (
 	"crypto/rand"
 	"net"
 	"novmm/platform"
+	"syscall"
 )
 
 //
@@ -122,6 +123,38 @@ func NewVirtioPciNet(info *DeviceInfo) (Device, error) {
 	return &VirtioNetDevice{VirtioDevice: device}, err
 }
 
+//
+// NicMac --
+//
+// Returns the MAC address of the given network device.
+//
+func (model *Model) NicMac(name string) (string, error) {
+	device := model.Lookup(name)
+	if device == nil {
+		return "", DeviceNotFound
+	}
+	nic, ok := device.(*VirtioNetDevice)
+	if !ok {
+		return "", DeviceNotNic
+	}
+	return nic.Mac, nil
+}
+
+func (nic *VirtioNetDevice) Detach(vm *platform.Vm, model *Model) error {
+	err := nic.VirtioDevice.Detach(vm, model)
+	if err != nil {
+		return err
+	}
+
+	// Close our tap device.
+	if nic.Fd >= 0 {
+		syscall.Close(nic.Fd)
+		nic.Fd = -1
+	}
+
+	return nil
+}
+
 func (nic *VirtioNetDevice) Attach(vm *platform.Vm, model *Model) error {
 	if nic.Vnet != 0 && nic.Vnet != VirtioNetHeaderSize {
 		return VirtioUnsupportedVnetHeader
@@ -152,6 +185,10 @@ func (nic *VirtioNetDevice) Attach(vm *platform.Vm, model *Model) error {
 		mac[0] = 0x28
 		mac[1] = 0x48
 		mac[2] = 0x46
+
+		// Save it, so the guest can be configured
+		// (and so that it's preserved across restore).
+		nic.Mac = mac.String()
 	}
 	nic.SetFeatures(VirtioNetFMac)
 	for i := 0; i < len(mac); i += 1 {
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"novmm/control"
 	"novmm/loader"
 	"novmm/machine"
@@ -28,6 +29,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // Our control server.
@@ -36,6 +38,12 @@ var control_fd = flag.Int("controlfd", -1, "bound control socket")
 // Machine state.
 var statefd = flag.Int("statefd", 0, "machine state file")
 
+// Restore from a snapshot.
+var restore = flag.String("restore", "", "snapshot file to restore")
+
+// Incoming migration.
+var incoming_fd = flag.Int("incomingfd", -1, "bound migration socket")
+
 // Guest-related flags.
 var real_init = flag.Bool("init", false, "real in-guest init?")
 
@@ -53,6 +61,13 @@ var debug = flag.Bool("debug", false, "devices start debugging")
 var paused = flag.Bool("paused", false, "start with model and vcpus paused")
 var stop = flag.Bool("stop", false, "wait for a SIGCONT before running")
 
+// Shutdown parameters.
+var shutdown_timeout = flag.Duration("shutdowntimeout", 30*time.Second, "time allowed for guest power off")
+
+// Should we load the kernel now?
+// Otherwise, it is only used for resets.
+var boot = flag.Bool("boot", true, "load the kernel on startup")
+
 func restart(
 	model *machine.Model,
 	vm *platform.Vm,
@@ -81,7 +96,7 @@ func restart(
 	}
 
 	// Create our state.
-	state, err := control.SaveState(vm, model)
+	state, err := control.SaveState(vm, model, nil)
 	if err != nil {
 		return err
 	}
@@ -102,8 +117,7 @@ func restart(
 	if err != nil {
 		return err
 	}
-	encoder := utils.NewEncoder(state_file)
-	err = encoder.Encode(&state)
+	err = control.WriteState(state_file, &state)
 	if err != nil {
 		return err
 	}
@@ -125,11 +139,72 @@ func restart(
 		fmt.Sprintf("-trace=%t", is_tracing),
 		fmt.Sprintf("-paused=%t", *paused),
 		fmt.Sprintf("-stop=%t", stop),
+		fmt.Sprintf("-shutdowntimeout=%s", *shutdown_timeout),
+	}
+
+	// Pass along our kernel.
+	// This is not loaded again, but we need
+	// it in order to be able to reset.
+	if *vmlinux != "" {
+		cmd = append(cmd,
+			"-boot=false",
+			fmt.Sprintf("-setup=%s", *boot_params),
+			fmt.Sprintf("-vmlinux=%s", *vmlinux),
+			fmt.Sprintf("-initrd=%s", *initrd),
+			fmt.Sprintf("-cmdline=%s", *cmdline),
+			fmt.Sprintf("-sysmap=%s", *system_map))
 	}
 
 	return syscall.Exec(bin, cmd, os.Environ())
 }
 
+func isFlagSet(name string) bool {
+	is_set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			is_set = true
+		}
+	})
+	return is_set
+}
+
+func loadState(fd int) (*control.State, error) {
+
+	state_file := os.NewFile(uintptr(fd), "state")
+	defer state_file.Close()
+
+	return control.ReadState(state_file)
+}
+
+func receive(
+	incoming_fd int,
+	local *control.State) (*control.State, net.Conn, error) {
+
+	// Accept our sender.
+	listener, err := net.FileListener(
+		os.NewFile(uintptr(incoming_fd), "incoming"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Read all memory and state.
+	// Our local state provides all host resources,
+	// (the memory to fill, as well as any other file
+	// descriptors), the rest is taken from the sender.
+	state, err := control.ReceiveMigration(conn, local)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return state, conn, nil
+}
+
 func main() {
 	// Start processing signals.
 	// Our setup can take a little while, so we
@@ -145,6 +220,12 @@ func main() {
 	// Parse all command line options.
 	flag.Parse()
 
+	// Never load the kernel over a migrated guest.
+	// As with restart(), the kernel is only for resets.
+	if *incoming_fd >= 0 {
+		*boot = false
+	}
+
 	// Are we doing a special restart?
 	// This will STOP the current process, and
 	// wait for a CONT signal before resuming.
@@ -172,16 +253,36 @@ func main() {
 	}
 
 	// Load our machine state.
-	state_file := os.NewFile(uintptr(*statefd), "state")
-	decoder := utils.NewDecoder(state_file)
-	state := new(control.State)
-	err = decoder.Decode(&state)
-	if err != nil {
-		utils.Die(err)
+	// When restoring, the state file only provides local
+	// resources (see LoadSnapshot). It may be omitted only
+	// if the snapshot has no devices holding host fds.
+	var state *control.State
+	if *restore == "" || isFlagSet("statefd") {
+		state, err = loadState(*statefd)
+		if err != nil {
+			utils.Die(err)
+		}
+	}
+	if *restore != "" {
+		log.Printf("Restoring snapshot...")
+		state, err = control.LoadSnapshot(*restore, state)
+		if err != nil {
+			utils.Die(err)
+		}
 	}
 
-	// We're done with the state file.
-	state_file.Close()
+	// Are we receiving a migration?
+	// If so, we don't acknowledge the sender until we are
+	// ready to run. Should we die before then, the sender
+	// will see the connection close and carry on.
+	var migration net.Conn
+	if *incoming_fd >= 0 {
+		log.Printf("Receiving migration...")
+		state, migration, err = receive(*incoming_fd, state)
+		if err != nil {
+			utils.Die(err)
+		}
+	}
 
 	// Load all devices.
 	log.Printf("Creating devices...")
@@ -236,7 +337,7 @@ func main() {
 	var sysmap loader.SystemMap
 	var convention *loader.Convention
 
-	if *vmlinux != "" {
+	if *vmlinux != "" && *boot {
 		log.Printf("Loading linux...")
 		sysmap, convention, err = loader.LoadLinux(
 			vcpus[0],
@@ -260,6 +361,15 @@ func main() {
 		tracer.Enable()
 	}
 
+	// Let the sender know we're ready.
+	if migration != nil {
+		err = control.FinishMigration(migration, nil)
+		migration.Close()
+		if err != nil {
+			utils.Die(err)
+		}
+	}
+
 	// Create our RPC server.
 	log.Printf("Starting control server...")
 	control, err := control.NewControl(
@@ -275,6 +385,16 @@ func main() {
 	}
 	go control.Serve()
 
+	// Create our resetter.
+	resetter := NewResetter(
+		vm,
+		model,
+		*boot_params,
+		*vmlinux,
+		*initrd,
+		*cmdline,
+		*system_map)
+
 	// Start all VCPUs.
 	// None of these will actually come online
 	// until the primary VCPU below delivers the
@@ -283,7 +403,15 @@ func main() {
 	vcpu_err := make(chan error)
 	for _, vcpu := range vcpus {
 		go func(vcpu *platform.Vcpu) {
-			err := Loop(vm, vcpu, model, tracer)
+			err := Loop(
+				vm,
+				vcpu,
+				model,
+				tracer,
+				control.VcpuMetrics(int(vcpu.Id)),
+				control.Debugger(),
+				resetter)
+			control.Events().VcpuExit(int(vcpu.Id), err)
 			vcpu_err <- err
 		}(vcpu)
 	}
@@ -294,8 +422,43 @@ func main() {
 	// a live upgrade (i.e. the binary has been replaced, we rerun).
 	vcpus_alive := len(vcpus)
 
+	// Notice when the guest powers off.
+	// This happens either when the guest itself
+	// enters S5, or when a shutdown gives up waiting.
+	powered_off := model.PoweredOff()
+
 	for {
 		select {
+		case <-powered_off:
+			log.Printf("Power off.")
+			control.Events().PowerOff()
+			os.Exit(0)
+
+		case <-control.Migrated():
+			log.Printf("Migrated.")
+			control.Events().Migrated()
+
+			// Give the reply (and the event) a moment
+			// to reach the caller before we go away.
+			time.Sleep(time.Second)
+			os.Exit(0)
+
+		case vcpu := <-resetter.Requests():
+			log.Printf("Reset.")
+			err := resetter.Reset(vcpu)
+			if err == NoKernel {
+				// We were restored or migrated without
+				// a kernel, so there's nothing to reboot.
+				// This is the same as powering off.
+				log.Printf("No kernel, power off.")
+				control.Events().PowerOff()
+				os.Exit(0)
+			} else if err != nil {
+				utils.Die(err)
+			}
+			control.Reset()
+			control.Events().Reset()
+
 		case err := <-vcpu_err:
 			vcpus_alive -= 1
 			if err != nil {
@@ -305,7 +468,16 @@ func main() {
 			switch sig {
 			case utils.SigShutdown:
 				log.Printf("Shutdown.")
-				os.Exit(0)
+
+				// Ask the guest nicely. If we're not
+				// able to, then we simply exit as before.
+				go func() {
+					err := control.Shutdown(*shutdown_timeout)
+					if err != nil {
+						log.Printf("Shutdown failed: %s", err.Error())
+						os.Exit(0)
+					}
+				}()
 
 			case utils.SigRestart:
 				fallthrough
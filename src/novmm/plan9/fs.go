@@ -452,6 +452,21 @@ func (fs *Fs) Attach() error {
 	return nil
 }
 
+func (fs *Fs) Close() {
+
+	fs.lruLock.Lock()
+	defer fs.lruLock.Unlock()
+
+	// Close all open descriptors.
+	// Note that all files with open descriptors
+	// will be in the LRU (see touchLru()).
+	for _, file := range fs.lru {
+		file.flush()
+		file.index = -1
+	}
+	fs.lru = fs.lru[0:0]
+}
+
 func (reqs *Reqlist) MarshalJSON() ([]byte, error) {
 
 	// Create an array.
@@ -31,3 +31,10 @@ var UnknownRegister = errors.New("Unknown Register")
 var NotPaused = errors.New("Vcpu is not paused?")
 var AlreadyPaused = errors.New("Vcpu is already paused.")
 var UnknownState = errors.New("Unknown vcpu state?")
+
+// Debug errors.
+var TooManyBreakpoints = errors.New("Too many hardware breakpoints?")
+var InvalidWatchpoint = errors.New("Invalid watchpoint length?")
+
+// Memory errors.
+var UnknownSlot = errors.New("Unknown memory slot?")
@@ -122,11 +122,60 @@ func (exit *ExitException) Error() string {
 		exit.errorCode)
 }
 
+//
+// Debug exit reasons.
+//
+type DebugReason int
+
+const (
+	DebugUnknown DebugReason = iota
+	DebugStep
+	DebugBreakpoint
+	DebugHardwareBreakpoint
+	DebugWatchpoint
+)
+
+var debugReasonNames = map[DebugReason]string{
+	DebugUnknown:            "unknown",
+	DebugStep:               "step",
+	DebugBreakpoint:         "breakpoint",
+	DebugHardwareBreakpoint: "hardware-breakpoint",
+	DebugWatchpoint:         "watchpoint",
+}
+
+func (reason DebugReason) String() string {
+	return debugReasonNames[reason]
+}
+
 type ExitDebug struct {
+	exception uint32
+	pc        Vaddr
+	dr6       uint64
+	dr7       uint64
+
+	// Decoded (see kvm_debug.go).
+	reason  DebugReason
+	address Vaddr
 }
 
 func (exit *ExitDebug) Error() string {
-	return fmt.Sprintf("Debug exit (single-step)")
+	return fmt.Sprintf(
+		"Debug exit (%s @ %x, pc: %x)",
+		exit.reason.String(),
+		exit.address,
+		exit.pc)
+}
+
+func (exit *ExitDebug) Reason() DebugReason {
+	return exit.reason
+}
+
+func (exit *ExitDebug) Address() Vaddr {
+	return exit.address
+}
+
+func (exit *ExitDebug) Pc() Vaddr {
+	return exit.pc
 }
 
 type ExitShutdown struct {
@@ -26,6 +26,7 @@ const int IoctlGetVcpuMmapSize = KVM_GET_VCPU_MMAP_SIZE;
 import "C"
 
 import (
+	"sync/atomic"
 	"syscall"
 )
 
@@ -42,6 +43,9 @@ type Vm struct {
 	// may even be different the 2nd time round).
 	mem_region int
 
+	// Our memory slots (see kvm_memory.go).
+	memory []*userMemory
+
 	// Our cpuid data.
 	// At the moment, we just expose the full
 	// host flags to the guest.
@@ -55,6 +59,9 @@ type Vm struct {
 
 	// Our vcpus.
 	vcpus []*Vcpu
+
+	// Our pause generation (see PauseGeneration).
+	pause_generation uint32
 }
 
 func getMmapSize(fd int) (int, error) {
@@ -131,6 +138,7 @@ func NewVm() (*Vm, error) {
 	vm := &Vm{
 		fd:        int(vmfd),
 		vcpus:     make([]*Vcpu, 0, 0),
+		memory:    make([]*userMemory, 0, 0),
 		cpuid:     cpuid,
 		msrs:      msrs,
 		mmap_size: mmap_size,
@@ -175,6 +183,9 @@ func (vm *Vm) VcpuInfo() ([]VcpuInfo, error) {
 
 func (vm *Vm) Pause(manual bool) error {
 
+	// Note that we're pausing.
+	atomic.AddUint32(&vm.pause_generation, 1)
+
 	// Pause all vcpus.
 	for i, vcpu := range vm.vcpus {
 		err := vcpu.Pause(manual)
@@ -192,6 +203,32 @@ func (vm *Vm) Pause(manual bool) error {
 	return nil
 }
 
+//
+// PauseGeneration --
+//
+// This changes whenever the whole VM is paused or
+// unpaused. Comparing two values tells the caller if
+// there may have been a pause in between (even if it
+// is not paused at either point).
+//
+func (vm *Vm) PauseGeneration() uint32 {
+	return atomic.LoadUint32(&vm.pause_generation)
+}
+
+//
+// IsStopped --
+//
+// Are all vcpus paused (for any reason)?
+//
+func (vm *Vm) IsStopped() bool {
+	for _, vcpu := range vm.vcpus {
+		if !vcpu.IsPaused() {
+			return false
+		}
+	}
+	return len(vm.vcpus) > 0
+}
+
 func (vm *Vm) Unpause(manual bool) error {
 
 	// Unpause all vcpus.
@@ -207,6 +244,9 @@ func (vm *Vm) Unpause(manual bool) error {
 		}
 	}
 
+	// Note that we've resumed.
+	atomic.AddUint32(&vm.pause_generation, 1)
+
 	// Done.
 	return nil
 }
@@ -0,0 +1,200 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+//
+// Hardware breakpoints and watchpoints --
+//
+// These are implemented using the debug registers,
+// which are programmed via KVM_SET_GUEST_DEBUG. There
+// are only four address registers, and these are
+// shared between breakpoints and watchpoints.
+//
+
+const DebugRegisters = 4
+
+type Watchpoint struct {
+	// The address watched.
+	Address Vaddr `json:"address"`
+
+	// The length (1, 2, 4 or 8).
+	Length uint `json:"length"`
+
+	// Trap on reads as well as writes?
+	Access bool `json:"access"`
+}
+
+// Debug register bits.
+const (
+	dr6Step    = 1 << 14
+	dr7Global  = 1 << 9
+	dr7Fixed   = 1 << 10
+	dr7Execute = 0x0
+	dr7Write   = 0x1
+	dr7Access  = 0x3
+)
+
+func dr7Length(length uint) (uint64, error) {
+	switch length {
+	case 1:
+		return 0x0, nil
+	case 2:
+		return 0x1, nil
+	case 4:
+		return 0x3, nil
+	case 8:
+		return 0x2, nil
+	}
+	return 0, InvalidWatchpoint
+}
+
+func debugRegisters(
+	breakpoints []Vaddr,
+	watchpoints []Watchpoint) ([DebugRegisters]uint64, uint64, error) {
+
+	var addrs [DebugRegisters]uint64
+	dr7 := uint64(0)
+
+	if len(breakpoints)+len(watchpoints) > DebugRegisters {
+		return addrs, 0, TooManyBreakpoints
+	}
+
+	slot := uint(0)
+	enable := func(addr Vaddr, rw uint64, length uint64) {
+		addrs[slot] = uint64(addr)
+		dr7 |= (1 << (2*slot + 1))
+		dr7 |= rw << (16 + 4*slot)
+		dr7 |= length << (18 + 4*slot)
+		slot += 1
+	}
+
+	for _, addr := range breakpoints {
+		enable(addr, dr7Execute, 0)
+	}
+	for _, watchpoint := range watchpoints {
+		length, err := dr7Length(watchpoint.Length)
+		if err != nil {
+			return addrs, 0, err
+		}
+		if watchpoint.Access {
+			enable(watchpoint.Address, dr7Access, length)
+		} else {
+			enable(watchpoint.Address, dr7Write, length)
+		}
+	}
+
+	if dr7 != 0 {
+		dr7 |= dr7Global | dr7Fixed
+	}
+
+	return addrs, dr7, nil
+}
+
+func (vcpu *Vcpu) debugRegisters() ([DebugRegisters]uint64, uint64, error) {
+	return debugRegisters(vcpu.hw_breakpoints, vcpu.hw_watchpoints)
+}
+
+//
+// CheckDebugRegisters --
+//
+// Ensure the given breakpoints and watchpoints will
+// fit in the debug registers, without touching any vcpu.
+//
+func CheckDebugRegisters(
+	breakpoints []Vaddr,
+	watchpoints []Watchpoint) error {
+
+	_, _, err := debugRegisters(breakpoints, watchpoints)
+	return err
+}
+
+//
+// SetDebugRegisters --
+//
+// Replace all hardware breakpoints and watchpoints.
+// Both are laid out together, so this must be done
+// at once (the registers are shared).
+//
+func (vcpu *Vcpu) SetDebugRegisters(
+	breakpoints []Vaddr,
+	watchpoints []Watchpoint) error {
+
+	orig_breakpoints := vcpu.hw_breakpoints
+	orig_watchpoints := vcpu.hw_watchpoints
+	vcpu.hw_breakpoints = breakpoints
+	vcpu.hw_watchpoints = watchpoints
+
+	err := vcpu.setGuestDebug(vcpu.is_stepping, vcpu.sw_breakpoints)
+	if err != nil {
+		vcpu.hw_breakpoints = orig_breakpoints
+		vcpu.hw_watchpoints = orig_watchpoints
+	}
+
+	return err
+}
+
+func (vcpu *Vcpu) GetBreakpoints() []Vaddr {
+	return vcpu.hw_breakpoints
+}
+
+func (vcpu *Vcpu) GetWatchpoints() []Watchpoint {
+	return vcpu.hw_watchpoints
+}
+
+func (vcpu *Vcpu) decodeDebug(exit *ExitDebug) {
+
+	switch exit.exception {
+	case 3:
+		// Software breakpoint (int3).
+		exit.reason = DebugBreakpoint
+		exit.address = exit.pc
+		return
+
+	case 1:
+		// Debug exception (#DB).
+		// Figure out which condition was hit.
+		if exit.dr6&dr6Step != 0 {
+			exit.reason = DebugStep
+			exit.address = exit.pc
+			return
+		}
+		for slot := uint(0); slot < DebugRegisters; slot += 1 {
+			if exit.dr6&(1<<slot) == 0 {
+				continue
+			}
+			index := int(slot)
+			if index < len(vcpu.hw_breakpoints) {
+				exit.reason = DebugHardwareBreakpoint
+				exit.address = vcpu.hw_breakpoints[index]
+				return
+			}
+			index -= len(vcpu.hw_breakpoints)
+			if index < len(vcpu.hw_watchpoints) {
+				exit.reason = DebugWatchpoint
+				exit.address = vcpu.hw_watchpoints[index].Address
+				return
+			}
+		}
+	}
+
+	// This may be a step without any
+	// information available (i.e. older kernels).
+	if vcpu.is_stepping {
+		exit.reason = DebugStep
+	} else {
+		exit.reason = DebugUnknown
+	}
+	exit.address = exit.pc
+}
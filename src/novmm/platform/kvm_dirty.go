@@ -0,0 +1,164 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux
+package platform
+
+/*
+#include <linux/kvm.h>
+#include "kvm_dirty.h"
+
+// IOCTL calls.
+const int IoctlGetDirtyLog = KVM_GET_DIRTY_LOG;
+*/
+import "C"
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+//
+// DirtyBitmap --
+//
+// One bit per page in a memory slot, set if the page
+// has been written since the log was last retrieved.
+//
+type DirtyBitmap []uint64
+
+func (bitmap DirtyBitmap) IsDirty(page uint64) bool {
+	return bitmap[page/64]&(1<<(page%64)) != 0
+}
+
+func (bitmap DirtyBitmap) Count() int {
+	count := 0
+	for _, word := range bitmap {
+		for ; word != 0; word &= word - 1 {
+			count += 1
+		}
+	}
+	return count
+}
+
+func (bitmap DirtyBitmap) Pages(start Paddr) []Paddr {
+
+	pages := make([]Paddr, 0, 0)
+	for i, word := range bitmap {
+		for bit := uint64(0); word != 0; bit += 1 {
+			if word&1 != 0 {
+				page := uint64(i)*64 + bit
+				pages = append(pages, start.After(page*PageSize))
+			}
+			word >>= 1
+		}
+	}
+
+	return pages
+}
+
+func (vm *Vm) findSlot(slot int) (*userMemory, error) {
+	for _, memory := range vm.memory {
+		if memory.Slot == slot {
+			return memory, nil
+		}
+	}
+	return nil, UnknownSlot
+}
+
+func (vm *Vm) setDirtyLog(slot int, enabled bool) error {
+
+	memory, err := vm.findSlot(slot)
+	if err != nil {
+		return err
+	}
+
+	// Update the slot flags.
+	orig_logging := memory.Logging
+	memory.Logging = enabled
+	err = vm.setUserMemory(memory)
+	if err != nil {
+		memory.Logging = orig_logging
+		return err
+	}
+
+	return nil
+}
+
+func (vm *Vm) EnableDirtyLog(slot int) error {
+	return vm.setDirtyLog(slot, true)
+}
+
+func (vm *Vm) DisableDirtyLog(slot int) error {
+	return vm.setDirtyLog(slot, false)
+}
+
+func (vm *Vm) getDirtyLog(memory *userMemory) (DirtyBitmap, error) {
+
+	// One bit per page, rounded up to a full word.
+	pages := (memory.Size + PageSize - 1) / PageSize
+	bitmap := make(DirtyBitmap, (pages+63)/64, (pages+63)/64)
+
+	var log C.struct_kvm_dirty_log
+	log.slot = C.__u32(memory.Slot)
+	C.dirty_log_set_bitmap(&log, unsafe.Pointer(&bitmap[0]))
+
+	// Fetch the log.
+	// NOTE: The kernel resets the log as it is
+	// retrieved, so this is also how we clear it.
+	_, _, e := syscall.Syscall(
+		syscall.SYS_IOCTL,
+		uintptr(vm.fd),
+		uintptr(C.IoctlGetDirtyLog),
+		uintptr(unsafe.Pointer(&log)))
+	if e != 0 {
+		return nil, e
+	}
+
+	return bitmap, nil
+}
+
+func (vm *Vm) GetDirtyLog() (map[Paddr]DirtyBitmap, error) {
+
+	bitmaps := make(map[Paddr]DirtyBitmap)
+
+	// Retrieve the log for all logged slots.
+	// These are keyed by the start of each slot.
+	for _, memory := range vm.memory {
+		if !memory.Logging {
+			continue
+		}
+		bitmap, err := vm.getDirtyLog(memory)
+		if err != nil {
+			return nil, err
+		}
+		bitmaps[memory.Start] = bitmap
+	}
+
+	return bitmaps, nil
+}
+
+func (vm *Vm) ClearDirtyLog() error {
+
+	for _, memory := range vm.memory {
+		if !memory.Logging {
+			continue
+		}
+		_, err := vm.getDirtyLog(memory)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -51,6 +51,14 @@ void* handle_exit_exception(struct kvm_run* kvm) {
     return kvmExitException(kvm->ex.exception, kvm->ex.error_code);
 }
 
+void* handle_exit_debug(struct kvm_run* kvm) {
+    return kvmExitDebug(
+        kvm->debug.arch.exception,
+        kvm->debug.arch.pc,
+        kvm->debug.arch.dr6,
+        kvm->debug.arch.dr7);
+}
+
 void* handle_exit_unknown(struct kvm_run* kvm) {
     return kvmExitUnknown(kvm->exit_reason);
 }
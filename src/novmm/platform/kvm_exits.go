@@ -76,6 +76,21 @@ func kvmExitException(
 	})
 }
 
+//export kvmExitDebug
+func kvmExitDebug(
+	exception C.__u32,
+	pc C.__u64,
+	dr6 C.__u64,
+	dr7 C.__u64) unsafe.Pointer {
+
+	return unsafe.Pointer(&ExitDebug{
+		exception: uint32(exception),
+		pc:        Vaddr(pc),
+		dr6:       uint64(dr6),
+		dr7:       uint64(dr7),
+	})
+}
+
 //export kvmExitUnknown
 func kvmExitUnknown(
 	code C.__u32) unsafe.Pointer {
@@ -97,7 +112,9 @@ func (vcpu *Vcpu) GetExitError() error {
 	case C.ExitReasonException:
 		return (*ExitException)(C.handle_exit_exception(vcpu.kvm))
 	case C.ExitReasonDebug:
-		return &ExitDebug{}
+		exit := (*ExitDebug)(C.handle_exit_debug(vcpu.kvm))
+		vcpu.decodeDebug(exit)
+		return exit
 	case C.ExitReasonShutdown:
 		return &ExitShutdown{}
 	default:
@@ -24,6 +24,7 @@ extern void* kvmExitMmio(__u64 addr, __u64* data, __u32 length, int write);
 extern void* kvmExitPio(__u16 port, __u8 size, void* data, __u32 length, int out);
 extern void* kvmExitInternalError(__u32 code);
 extern void* kvmExitException(__u32 exception, __u32 error_code);
+extern void* kvmExitDebug(__u32 exception, __u64 pc, __u64 dr6, __u64 dr7);
 extern void* kvmExitUnknown(__u32 code);
 
 extern const int ExitReasonMmio;
@@ -37,4 +38,5 @@ void* handle_exit_mmio(struct kvm_run* kvm);
 void* handle_exit_io(struct kvm_run* kvm);
 void* handle_exit_internal_error(struct kvm_run* kvm);
 void* handle_exit_exception(struct kvm_run* kvm);
+void* handle_exit_debug(struct kvm_run* kvm);
 void* handle_exit_unknown(struct kvm_run* kvm);
@@ -32,10 +32,40 @@ import (
 	"unsafe"
 )
 
-func (vm *Vm) MapUserMemory(
-	start Paddr,
-	size uint64,
-	mmap []byte) error {
+//
+// MemorySlot --
+//
+// A single KVM memory slot, as created by MapUserMemory.
+// We keep these around so that the slot flags can be changed
+// later on (i.e. to enable dirty logging) without remapping.
+//
+type MemorySlot struct {
+	// The slot number.
+	Slot int `json:"slot"`
+
+	// The guest physical region.
+	Start Paddr  `json:"start"`
+	Size  uint64 `json:"size"`
+
+	// Are we logging dirty pages?
+	Logging bool `json:"logging"`
+}
+
+type userMemory struct {
+	MemorySlot
+
+	// The backing memory.
+	mmap []byte
+}
+
+func (memory *userMemory) flags() uint32 {
+	if memory.Logging {
+		return uint32(C.IoctlFlagMemLogDirtyPages)
+	}
+	return 0
+}
+
+func (vm *Vm) setUserMemory(memory *userMemory) error {
 
 	// See NOTE above about read-only memory.
 	// As we will not support it for the moment,
@@ -43,11 +73,11 @@ func (vm *Vm) MapUserMemory(
 	// Leveraging that feature will likely require
 	// a small amount of re-architecting in any case.
 	var region C.struct_kvm_userspace_memory_region
-	region.slot = C.__u32(vm.mem_region)
-	region.flags = C.__u32(0)
-	region.guest_phys_addr = C.__u64(start)
-	region.memory_size = C.__u64(size)
-	region.userspace_addr = C.__u64(uintptr(unsafe.Pointer(&mmap[0])))
+	region.slot = C.__u32(memory.Slot)
+	region.flags = C.__u32(memory.flags())
+	region.guest_phys_addr = C.__u64(memory.Start)
+	region.memory_size = C.__u64(memory.Size)
+	region.userspace_addr = C.__u64(uintptr(unsafe.Pointer(&memory.mmap[0])))
 
 	// Execute the ioctl.
 	_, _, e := syscall.Syscall(
@@ -59,11 +89,44 @@ func (vm *Vm) MapUserMemory(
 		return e
 	}
 
+	return nil
+}
+
+func (vm *Vm) MapUserMemory(
+	start Paddr,
+	size uint64,
+	mmap []byte) error {
+
+	memory := &userMemory{
+		MemorySlot: MemorySlot{
+			Slot:  vm.mem_region,
+			Start: start,
+			Size:  size,
+		},
+		mmap: mmap,
+	}
+
+	err := vm.setUserMemory(memory)
+	if err != nil {
+		return err
+	}
+
 	// We're set, bump our slot.
+	vm.memory = append(vm.memory, memory)
 	vm.mem_region += 1
 	return nil
 }
 
+func (vm *Vm) MemorySlots() []MemorySlot {
+
+	slots := make([]MemorySlot, 0, len(vm.memory))
+	for _, memory := range vm.memory {
+		slots = append(slots, memory.MemorySlot)
+	}
+
+	return slots
+}
+
 func (vm *Vm) MapReservedMemory(
 	start Paddr,
 	size uint64) error {
@@ -90,7 +153,7 @@ func (vcpu *Vcpu) Translate(
 	paddr := Paddr(translation.physical_address)
 	valid := translation.valid != C.__u8(0)
 	writeable := translation.writeable != C.__u8(0)
-	usermode := translation.valid != C.__u8(0)
+	usermode := translation.usermode != C.__u8(0)
 
 	return paddr, valid, writeable, usermode, nil
 }
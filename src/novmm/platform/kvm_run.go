@@ -85,10 +85,12 @@ func (vcpu *Vcpu) Run() error {
 		// before we can declare a VCPU as "paused".
 		vcpu.RunInfo.lock.Lock()
 
+		was_paused := false
 		for vcpu.RunInfo.is_paused || vcpu.RunInfo.paused > 0 {
 			// Note that we are not running,
 			// See NOTE above about what this means.
 			vcpu.RunInfo.is_running = false
+			was_paused = true
 
 			// Send a notification that we are paused.
 			vcpu.RunInfo.pause_event.Broadcast()
@@ -97,6 +99,17 @@ func (vcpu *Vcpu) Run() error {
 			vcpu.RunInfo.resume_event.Wait()
 		}
 
+		// Our registers may have been accessed while we
+		// were paused (i.e. by a debugger), so make sure
+		// that nothing is left dirty or cached.
+		if was_paused {
+			err = vcpu.flushAllRegs()
+			if err != nil {
+				vcpu.RunInfo.lock.Unlock()
+				return err
+			}
+		}
+
 		vcpu.RunInfo.is_running = true
 		vcpu.RunInfo.lock.Unlock()
 
@@ -158,6 +171,27 @@ func (vcpu *Vcpu) Pause(manual bool) error {
 	return nil
 }
 
+func (vcpu *Vcpu) PauseSelf(manual bool) error {
+	// Acquire our runlock.
+	vcpu.RunInfo.lock.Lock()
+	defer vcpu.RunInfo.lock.Unlock()
+
+	// This may only be called from the vcpu thread,
+	// in between exits (i.e. for a debug exit). Unlike
+	// Pause(), we can't wait for the vcpu to stop, but
+	// it will not run again until a matching Unpause().
+	if manual {
+		if vcpu.RunInfo.is_paused {
+			return AlreadyPaused
+		}
+		vcpu.RunInfo.is_paused = true
+	} else {
+		vcpu.RunInfo.paused += 1
+	}
+
+	return nil
+}
+
 func (vcpu *Vcpu) Unpause(manual bool) error {
 	// Acquire our runlock.
 	vcpu.RunInfo.lock.Lock()
@@ -190,3 +224,11 @@ func (vcpu *Vcpu) Unpause(manual bool) error {
 
 	return nil
 }
+
+func (vcpu *Vcpu) IsPaused() bool {
+	// Acquire our runlock.
+	vcpu.RunInfo.lock.Lock()
+	defer vcpu.RunInfo.lock.Unlock()
+
+	return vcpu.RunInfo.is_paused || vcpu.RunInfo.paused > 0
+}
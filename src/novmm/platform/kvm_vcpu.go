@@ -23,7 +23,10 @@ const int IoctlCreateVcpu = KVM_CREATE_VCPU;
 const int IoctlSetGuestDebug = KVM_SET_GUEST_DEBUG;
 
 // IOCTL flags.
-const int IoctlGuestDebugEnable = KVM_GUESTDBG_ENABLE|KVM_GUESTDBG_SINGLESTEP;
+const int IoctlGuestDebugEnable = KVM_GUESTDBG_ENABLE;
+const int IoctlGuestDebugSingleStep = KVM_GUESTDBG_SINGLESTEP;
+const int IoctlGuestDebugUseSwBp = KVM_GUESTDBG_USE_SW_BP;
+const int IoctlGuestDebugUseHwBp = KVM_GUESTDBG_USE_HW_BP;
 */
 import "C"
 
@@ -65,6 +68,16 @@ type Vcpu struct {
 	// Is this stepping?
 	is_stepping bool
 
+	// Are we intercepting software breakpoints?
+	sw_breakpoints bool
+
+	// Our hardware breakpoints (see kvm_debug.go).
+	hw_breakpoints []Vaddr
+	hw_watchpoints []Watchpoint
+
+	// Our power-on state (see Reset()).
+	reset_info VcpuInfo
+
 	// Our run information.
 	RunInfo
 }
@@ -135,17 +148,30 @@ func (vcpu *Vcpu) Dispose() error {
 	return syscall.Close(vcpu.fd)
 }
 
-func (vcpu *Vcpu) SetStepping(step bool) error {
+func (vcpu *Vcpu) setGuestDebug(step bool, sw_breakpoints bool) error {
 
 	var guest_debug C.struct_kvm_guest_debug
 
-	if step == vcpu.is_stepping {
-		// Already set.
-		return nil
-	} else if step {
+	addrs, dr7, err := vcpu.debugRegisters()
+	if err != nil {
+		return err
+	}
+
+	if step || sw_breakpoints || dr7 != 0 {
 		guest_debug.control = C.__u32(C.IoctlGuestDebugEnable)
-	} else {
-		guest_debug.control = 0
+	}
+	if step {
+		guest_debug.control |= C.__u32(C.IoctlGuestDebugSingleStep)
+	}
+	if sw_breakpoints {
+		guest_debug.control |= C.__u32(C.IoctlGuestDebugUseSwBp)
+	}
+	if dr7 != 0 {
+		guest_debug.control |= C.__u32(C.IoctlGuestDebugUseHwBp)
+		for i, addr := range addrs {
+			guest_debug.arch.debugreg[i] = C.__u64(addr)
+		}
+		guest_debug.arch.debugreg[7] = C.__u64(dr7)
 	}
 
 	// Execute our debug ioctl.
@@ -160,9 +186,33 @@ func (vcpu *Vcpu) SetStepping(step bool) error {
 
 	// We're okay.
 	vcpu.is_stepping = step
+	vcpu.sw_breakpoints = sw_breakpoints
 	return nil
 }
 
+func (vcpu *Vcpu) SetStepping(step bool) error {
+
+	if step == vcpu.is_stepping {
+		// Already set.
+		return nil
+	}
+
+	return vcpu.setGuestDebug(step, vcpu.sw_breakpoints)
+}
+
 func (vcpu *Vcpu) IsStepping() bool {
 	return vcpu.is_stepping
 }
+
+func (vcpu *Vcpu) SetSoftwareBreakpoints(enabled bool) error {
+
+	// When enabled, int3 instructions executed by
+	// the guest will exit with ExitDebug, instead of
+	// being delivered to the guest as an exception.
+	if enabled == vcpu.sw_breakpoints {
+		// Already set.
+		return nil
+	}
+
+	return vcpu.setGuestDebug(vcpu.is_stepping, enabled)
+}
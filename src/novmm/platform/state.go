@@ -74,6 +74,17 @@ func (vm *Vm) CreateVcpus(spec []VcpuInfo) ([]*Vcpu, error) {
 			return nil, err
 		}
 
+		// Save the power-on state.
+		// KVM doesn't provide any way to reset a vcpu,
+		// so we grab this before anything is loaded. We
+		// don't keep the cpuid, which isn't touched by
+		// a reset (and may have been set below).
+		vcpu.reset_info, err = NewVcpuInfo(vcpu)
+		if err != nil {
+			return nil, err
+		}
+		vcpu.reset_info.Cpuid = nil
+
 		// Load the state.
 		err = vcpu.Load(info)
 		if err != nil {
@@ -165,6 +176,16 @@ func (vcpu *Vcpu) Load(info VcpuInfo) error {
 	return nil
 }
 
+//
+// Reset --
+//
+// Put the vcpu back into its power-on state.
+// The vcpu must be paused while this happens.
+//
+func (vcpu *Vcpu) Reset() error {
+	return vcpu.Load(vcpu.reset_info)
+}
+
 func NewVcpuInfo(vcpu *Vcpu) (VcpuInfo, error) {
 
 	err := vcpu.Pause(false)
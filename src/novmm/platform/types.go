@@ -49,3 +49,18 @@ func (paddr Paddr) OffsetFrom(base Paddr) uint64 {
 func (paddr Paddr) After(length uint64) Paddr {
 	return Paddr(uint64(paddr) + uint64(length))
 }
+
+// Sortable addresses.
+type PaddrList []Paddr
+
+func (list PaddrList) Len() int {
+	return len(list)
+}
+
+func (list PaddrList) Swap(i int, j int) {
+	list[i], list[j] = list[j], list[i]
+}
+
+func (list PaddrList) Less(i int, j int) bool {
+	return list[i] < list[j]
+}
@@ -0,0 +1,156 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"novmm/loader"
+	"novmm/machine"
+	"novmm/platform"
+)
+
+//
+// Resetter --
+//
+// Handles a machine reset. A reset is requested by
+// a vcpu (i.e. on a triple fault or a write to the ACPI
+// reset register), but it's carried out by the main loop
+// once all vcpus have stopped. We put every device and
+// vcpu back into the power-on state and load the kernel
+// again. Other than the kernel, this is what the guest
+// would see on a real machine.
+//
+type Resetter struct {
+	vm    *platform.Vm
+	model *machine.Model
+
+	// Our kernel (see LoadLinux).
+	boot_params string
+	vmlinux     string
+	initrd      string
+	cmdline     string
+	system_map  string
+
+	// Vcpus waiting on a reset.
+	requests chan *platform.Vcpu
+}
+
+func NewResetter(
+	vm *platform.Vm,
+	model *machine.Model,
+	boot_params string,
+	vmlinux string,
+	initrd string,
+	cmdline string,
+	system_map string) *Resetter {
+
+	return &Resetter{
+		vm:          vm,
+		model:       model,
+		boot_params: boot_params,
+		vmlinux:     vmlinux,
+		initrd:      initrd,
+		cmdline:     cmdline,
+		system_map:  system_map,
+		requests:    make(chan *platform.Vcpu, len(vm.Vcpus())),
+	}
+}
+
+//
+// Request --
+//
+// Ask for a reset. This must be called from the
+// vcpu thread. The vcpu will not run again until
+// the reset has been completed.
+//
+func (resetter *Resetter) Request(vcpu *platform.Vcpu) error {
+	err := vcpu.PauseSelf(false)
+	if err != nil {
+		return err
+	}
+	resetter.requests <- vcpu
+	return nil
+}
+
+func (resetter *Resetter) Requests() <-chan *platform.Vcpu {
+	return resetter.requests
+}
+
+//
+// Reset --
+//
+// Reset the machine (see above), starting with the
+// given request. Any other vcpus that have asked for
+// a reset at the same time are satisfied by this one.
+//
+func (resetter *Resetter) Reset(vcpu *platform.Vcpu) error {
+
+	// Can we actually come back up?
+	// NOTE: Nothing has been touched yet, so the
+	// caller is free to treat this as a power off.
+	if resetter.vmlinux == "" {
+		return NoKernel
+	}
+
+	// Stop everything.
+	err := resetter.vm.Pause(false)
+	if err != nil {
+		return err
+	}
+	defer resetter.vm.Unpause(false)
+
+	// Collect all pending requests.
+	// Since all vcpus are now paused, there's
+	// nobody who could make a new one.
+	requesters := []*platform.Vcpu{vcpu}
+	for draining := true; draining; {
+		select {
+		case other := <-resetter.requests:
+			requesters = append(requesters, other)
+		default:
+			draining = false
+		}
+	}
+	defer func() {
+		for _, requester := range requesters {
+			requester.Unpause(false)
+		}
+	}()
+
+	// Reset all devices.
+	err = resetter.model.Reset(resetter.vm)
+	if err != nil {
+		return err
+	}
+
+	// Reset all vcpus.
+	vcpus := resetter.vm.Vcpus()
+	for _, vcpu := range vcpus {
+		err = vcpu.Reset()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Load the kernel again.
+	_, _, err = loader.LoadLinux(
+		vcpus[0],
+		resetter.model,
+		resetter.boot_params,
+		resetter.vmlinux,
+		resetter.initrd,
+		resetter.cmdline,
+		resetter.system_map)
+	return err
+}